@@ -0,0 +1,194 @@
+package beaconImp
+
+import (
+	"math"
+	"sort"
+
+	"beacon/config"
+)
+
+// ========== Battle - 战斗结算 ==========
+//
+// 战斗分为两个阶段：
+//  1. 远程阶段：双方同时以远程攻击力对抗对方的远程防御力，只进行一轮
+//  2. 近战阶段：幸存部队以近战攻击力对抗对方的近战防御力，最多进行 battleMeleeRounds 轮
+//
+// 每一轮中，一方的伤亡比例 = 对方攻击力 / (对方攻击力 + 己方防御力)，
+// 该方的伤亡总数按比例向下取整，不足1人的小数部分累计到下一轮，避免小股部队永远不死；
+// 取整后的伤亡按各兵种小数部分从大到小分配（最大余数法）。结算完全确定，不含随机因素。
+// 防守方全灭且进攻方仍有幸存时进攻方获胜，否则防守方获胜（同归于尽算防守成功）。
+
+const battleMeleeRounds = 3 // 近战最大轮数
+
+// TroopStatsLookup 兵种属性查询函数（便于脱离全局配置进行测试，也用于计入科技加成）
+type TroopStatsLookup func(troopType TroopType) *config.TroopAttr
+
+// ConfigTroopStats 从全局配置查询兵种属性
+func ConfigTroopStats(troopType TroopType) *config.TroopAttr {
+	return config.GetTroopConfig(string(troopType))
+}
+
+// BattleResult 战斗结果
+type BattleResult struct {
+	AttackerWon       bool              `json:"attacker_won"`
+	AttackerLosses    map[TroopType]int `json:"attacker_losses"`
+	DefenderLosses    map[TroopType]int `json:"defender_losses"`
+	AttackerSurvivors []*Troop          `json:"attacker_survivors"` // 与输入顺序一一对应
+	DefenderSurvivors []*Troop          `json:"defender_survivors"` // 与输入顺序一一对应
+}
+
+type battlePhase int
+
+const (
+	phaseRanged battlePhase = iota
+	phaseMelee
+)
+
+// ResolveBattle 结算一场战斗（不修改输入部队）
+// 攻守双方分别使用各自的兵种属性查询函数（双方科技加成不同）
+// 返回的幸存部队与输入一一对应（数量可能为0），调用者可按下标回写各来源
+func ResolveBattle(attackers, defenders []*Troop, atkStats, defStats TroopStatsLookup) *BattleResult {
+	atk := copyTroops(attackers)
+	def := copyTroops(defenders)
+
+	if totalQuantity(def) > 0 && totalQuantity(atk) > 0 {
+		var atkCarry, defCarry float64
+		atkCarry, defCarry = fightRound(atk, def, phaseRanged, atkStats, defStats, atkCarry, defCarry)
+		for round := 0; round < battleMeleeRounds; round++ {
+			if totalQuantity(atk) == 0 || totalQuantity(def) == 0 {
+				break
+			}
+			atkCarry, defCarry = fightRound(atk, def, phaseMelee, atkStats, defStats, atkCarry, defCarry)
+		}
+	}
+
+	return &BattleResult{
+		AttackerWon:       totalQuantity(atk) > 0 && totalQuantity(def) == 0,
+		AttackerLosses:    calcLosses(attackers, atk),
+		DefenderLosses:    calcLosses(defenders, def),
+		AttackerSurvivors: atk,
+		DefenderSurvivors: def,
+	}
+}
+
+// fightRound 进行一轮交战（双方同时结算），返回双方累计到下一轮的小数伤亡
+func fightRound(atk, def []*Troop, phase battlePhase, atkStats, defStats TroopStatsLookup, atkCarry, defCarry float64) (float64, float64) {
+	atkPower, atkDefense := sidePower(atk, phase, atkStats)
+	defPower, defDefense := sidePower(def, phase, defStats)
+
+	atkLossRatio := lossRatio(defPower, atkDefense)
+	defLossRatio := lossRatio(atkPower, defDefense)
+
+	return applyLossRatio(atk, atkLossRatio, atkCarry), applyLossRatio(def, defLossRatio, defCarry)
+}
+
+// sidePower 计算一方在指定阶段的总攻击力和总防御力
+func sidePower(troops []*Troop, phase battlePhase, stats TroopStatsLookup) (float64, float64) {
+	var power, defense float64
+	for _, t := range troops {
+		attr := stats(t.Type)
+		if attr == nil || t.Quantity <= 0 {
+			continue
+		}
+		qty := float64(t.Quantity)
+		if phase == phaseRanged {
+			power += qty * float64(attr.RangedAttack)
+			defense += qty * float64(attr.RangedDefense)
+		} else {
+			power += qty * float64(attr.MeleeAttack)
+			defense += qty * float64(attr.MeleeDefense)
+		}
+	}
+	return power, defense
+}
+
+// lossRatio 根据对方攻击力和己方防御力计算伤亡比例
+func lossRatio(enemyPower, defense float64) float64 {
+	if enemyPower <= 0 {
+		return 0
+	}
+	return enemyPower / (enemyPower + defense)
+}
+
+// applyLossRatio 按比例扣减各兵种数量，carry 为之前累计未结算的小数伤亡，返回新的累计值
+func applyLossRatio(troops []*Troop, ratio float64, carry float64) float64 {
+	if ratio <= 0 {
+		return carry
+	}
+
+	exact := make([]float64, len(troops))
+	expected := carry
+	for i, t := range troops {
+		exact[i] = float64(t.Quantity) * ratio
+		expected += exact[i]
+	}
+	// 容忍浮点误差（如 7/10*10 略小于7）
+	total := min(int(math.Floor(expected+1e-9)), totalQuantity(troops))
+
+	// 先扣除各兵种的整数部分，剩余伤亡按小数部分从大到小分配（相同时靠前的兵种优先）
+	losses := make([]int, len(troops))
+	fractions := make([]float64, len(troops))
+	remaining := total
+	for i := range troops {
+		losses[i] = min(int(math.Floor(exact[i]+1e-9)), troops[i].Quantity)
+		fractions[i] = exact[i] - float64(losses[i])
+		remaining -= losses[i]
+	}
+	order := make([]int, len(troops))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return fractions[order[a]] > fractions[order[b]]
+	})
+	for remaining > 0 {
+		assigned := false
+		for _, i := range order {
+			if remaining == 0 {
+				break
+			}
+			if losses[i] < troops[i].Quantity {
+				losses[i]++
+				remaining--
+				assigned = true
+			}
+		}
+		if !assigned {
+			break
+		}
+	}
+
+	for i, t := range troops {
+		t.Quantity -= losses[i]
+	}
+	return max(expected-float64(total), 0)
+}
+
+// calcLosses 对比战前战后，统计各兵种损失
+func calcLosses(before, after []*Troop) map[TroopType]int {
+	losses := make(map[TroopType]int)
+	for i, t := range before {
+		if lost := t.Quantity - after[i].Quantity; lost > 0 {
+			losses[t.Type] += lost
+		}
+	}
+	return losses
+}
+
+// copyTroops 深拷贝部队列表
+func copyTroops(troops []*Troop) []*Troop {
+	copied := make([]*Troop, 0, len(troops))
+	for _, t := range troops {
+		copied = append(copied, &Troop{Type: t.Type, Quantity: t.Quantity})
+	}
+	return copied
+}
+
+// totalQuantity 部队总数量
+func totalQuantity(troops []*Troop) int {
+	total := 0
+	for _, t := range troops {
+		total += t.Quantity
+	}
+	return total
+}
@@ -0,0 +1,167 @@
+package beaconImp
+
+import (
+	"reflect"
+	"testing"
+
+	"beacon/config"
+)
+
+// stubTroopStats 固定的兵种属性（不依赖全局配置）
+func stubTroopStats(attrs map[TroopType]*config.TroopAttr) TroopStatsLookup {
+	return func(troopType TroopType) *config.TroopAttr {
+		return attrs[troopType]
+	}
+}
+
+var testTroopStats = stubTroopStats(map[TroopType]*config.TroopAttr{
+	"swordsman": {MeleeAttack: 10, MeleeDefense: 5, RangedDefense: 5},
+	"archer":    {RangedAttack: 8, RangedDefense: 2, MeleeAttack: 2, MeleeDefense: 2},
+	"militia":   {MeleeAttack: 1, RangedAttack: 1, MeleeDefense: 7, RangedDefense: 7},
+	"wall":      {MeleeAttack: 3, RangedAttack: 3, MeleeDefense: 1000, RangedDefense: 1000},
+})
+
+func TestResolveBattleNoDefenders(t *testing.T) {
+	attackers := []*Troop{{Type: "swordsman", Quantity: 10}}
+	result := ResolveBattle(attackers, nil, testTroopStats, testTroopStats)
+
+	if !result.AttackerWon {
+		t.Fatal("attacker should win against an empty city")
+	}
+	if len(result.AttackerLosses) != 0 {
+		t.Fatalf("attacker losses = %v, want none", result.AttackerLosses)
+	}
+	if result.AttackerSurvivors[0].Quantity != 10 {
+		t.Fatalf("attacker survivors = %d, want 10", result.AttackerSurvivors[0].Quantity)
+	}
+}
+
+func TestResolveBattleRounds(t *testing.T) {
+	attackers := []*Troop{{Type: "swordsman", Quantity: 100}}
+	defenders := []*Troop{{Type: "archer", Quantity: 50}}
+	result := ResolveBattle(attackers, defenders, testTroopStats, testTroopStats)
+
+	// 远程：进攻方伤亡 400/900*100=44.4；近战1：进攻方 15、防守方 42；近战2：进攻方 3、防守方全灭
+	if !result.AttackerWon {
+		t.Fatal("attacker should win")
+	}
+	if got := result.AttackerSurvivors[0].Quantity; got != 38 {
+		t.Fatalf("attacker survivors = %d, want 38", got)
+	}
+	if got := result.AttackerLosses["swordsman"]; got != 62 {
+		t.Fatalf("attacker losses = %d, want 62", got)
+	}
+	if got := result.DefenderLosses["archer"]; got != 50 {
+		t.Fatalf("defender losses = %d, want 50", got)
+	}
+}
+
+func TestResolveBattleDoesNotModifyInput(t *testing.T) {
+	attackers := []*Troop{{Type: "swordsman", Quantity: 100}}
+	defenders := []*Troop{{Type: "archer", Quantity: 50}}
+	ResolveBattle(attackers, defenders, testTroopStats, testTroopStats)
+
+	if attackers[0].Quantity != 100 || defenders[0].Quantity != 50 {
+		t.Fatalf("input troops modified: attackers=%d, defenders=%d", attackers[0].Quantity, defenders[0].Quantity)
+	}
+}
+
+func TestResolveBattleDeterministic(t *testing.T) {
+	attackers := []*Troop{{Type: "swordsman", Quantity: 37}, {Type: "archer", Quantity: 23}, {Type: "militia", Quantity: 5}}
+	defenders := []*Troop{{Type: "archer", Quantity: 41}, {Type: "militia", Quantity: 12}}
+
+	first := ResolveBattle(attackers, defenders, testTroopStats, testTroopStats)
+	for i := 0; i < 10; i++ {
+		if again := ResolveBattle(attackers, defenders, testTroopStats, testTroopStats); !reflect.DeepEqual(first, again) {
+			t.Fatalf("battle result differs between runs: %+v vs %+v", first, again)
+		}
+	}
+}
+
+func TestResolveBattleSurvivorsKeepInputOrder(t *testing.T) {
+	attackers := []*Troop{{Type: "militia", Quantity: 3}, {Type: "swordsman", Quantity: 40}, {Type: "militia", Quantity: 2}}
+	defenders := []*Troop{{Type: "archer", Quantity: 10}}
+	result := ResolveBattle(attackers, defenders, testTroopStats, testTroopStats)
+
+	if len(result.AttackerSurvivors) != len(attackers) {
+		t.Fatalf("attacker survivors = %d entries, want %d", len(result.AttackerSurvivors), len(attackers))
+	}
+	total := 0
+	for i, s := range result.AttackerSurvivors {
+		if s.Type != attackers[i].Type {
+			t.Fatalf("survivor %d type = %s, want %s", i, s.Type, attackers[i].Type)
+		}
+		total += attackers[i].Quantity - s.Quantity
+	}
+	lost := 0
+	for _, n := range result.AttackerLosses {
+		lost += n
+	}
+	if lost != total {
+		t.Fatalf("attacker losses = %d, survivors imply %d", lost, total)
+	}
+}
+
+func TestResolveBattleSingleUnitCanDie(t *testing.T) {
+	// 每轮伤亡比例 3/(3+7)=0.3，逐轮取整永远不会死亡；累计 4 轮后伤亡 1.2
+	attackers := []*Troop{{Type: "militia", Quantity: 1}}
+	defenders := []*Troop{{Type: "wall", Quantity: 1}}
+	result := ResolveBattle(attackers, defenders, testTroopStats, testTroopStats)
+
+	if result.AttackerSurvivors[0].Quantity != 0 {
+		t.Fatalf("attacker survivors = %d, want 0", result.AttackerSurvivors[0].Quantity)
+	}
+	if result.AttackerWon {
+		t.Fatal("attacker should lose")
+	}
+	if result.DefenderSurvivors[0].Quantity != 1 {
+		t.Fatalf("defender survivors = %d, want 1", result.DefenderSurvivors[0].Quantity)
+	}
+}
+
+func TestApplyLossRatioLargestRemainder(t *testing.T) {
+	troops := []*Troop{{Type: "archer", Quantity: 1}, {Type: "swordsman", Quantity: 3}, {Type: "militia", Quantity: 1}}
+	// 期望伤亡 0.4 + 1.2 + 0.4 = 2：剑士先扣 1，剩余 1 人分给小数部分最大的兵种（相同时靠前优先）
+	carry := applyLossRatio(troops, 0.4, 0)
+
+	want := []int{0, 2, 1}
+	for i, t2 := range troops {
+		if t2.Quantity != want[i] {
+			t.Fatalf("troop %d (%s) = %d, want %d", i, t2.Type, t2.Quantity, want[i])
+		}
+	}
+	if carry > 1e-6 {
+		t.Fatalf("carry = %f, want 0", carry)
+	}
+}
+
+func TestApplyLossRatioCarry(t *testing.T) {
+	troops := []*Troop{{Type: "militia", Quantity: 1}}
+
+	carry := applyLossRatio(troops, 0.3, 0)
+	if troops[0].Quantity != 1 {
+		t.Fatalf("quantity after first round = %d, want 1", troops[0].Quantity)
+	}
+	carry = applyLossRatio(troops, 0.3, carry)
+	if troops[0].Quantity != 1 {
+		t.Fatalf("quantity after second round = %d, want 1", troops[0].Quantity)
+	}
+	carry = applyLossRatio(troops, 0.5, carry)
+	if troops[0].Quantity != 0 {
+		t.Fatalf("quantity after third round = %d, want 0", troops[0].Quantity)
+	}
+	if carry < 0 || carry >= 1 {
+		t.Fatalf("carry = %f, want within [0, 1)", carry)
+	}
+}
+
+func TestApplyLossRatioExactCount(t *testing.T) {
+	// 按损失人数反推的比例（侦察）必须扣除准确的人数
+	for losses := 1; losses < 10; losses++ {
+		troops := []*Troop{{Type: "scout", Quantity: 10}}
+		applyLossRatio(troops, float64(losses)/10, 0)
+		if got := 10 - troops[0].Quantity; got != losses {
+			t.Fatalf("losses = %d, want %d", got, losses)
+		}
+	}
+}
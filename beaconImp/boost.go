@@ -0,0 +1,63 @@
+package beaconImp
+
+import "beacon/config"
+
+// ========== Boost - 建筑加速效果 ==========
+
+// maxSpeedBoostPercent 加速百分比上限（加速后至少保留原耗时的10%，耗时不会变为0）
+const maxSpeedBoostPercent = 90
+
+// applySpeedBoost 按加速百分比缩短时间（加速上限 maxSpeedBoostPercent）
+func applySpeedBoost(seconds float64, boostPercent int) float64 {
+	boostPercent = max(0, min(boostPercent, maxSpeedBoostPercent))
+	return seconds * float64(100-boostPercent) / 100.0
+}
+
+// GetBuildSpeedBoost 获取城池当前的建造加速百分比（所有建筑之和，目前为官府）
+func (c *City) GetBuildSpeedBoost() int {
+	boost := 0
+	for _, b := range c.GetAllBuildings() {
+		if conf := config.GetBuildingLevel(string(b.Type), b.Level); conf != nil {
+			boost += conf.BuildSpeedBoost
+		}
+	}
+	return boost
+}
+
+// CalcBuildingUpgradeTime 计算建筑升级实际耗时（秒）
+// 加速按加入队列时的建筑等级计算；官府升级后不会追溯缩短已排队的任务
+func (c *City) CalcBuildingUpgradeTime(levelConf *config.BuildingLevelConf) float64 {
+	return applySpeedBoost(float64(levelConf.UpgradeTimeSeconds), c.GetBuildSpeedBoost())
+}
+
+// GetRecruitSpeedBoost 获取城池当前的招募加速百分比（所有建筑之和，目前为兵营）
+func (c *City) GetRecruitSpeedBoost() int {
+	boost := 0
+	for _, b := range c.GetAllBuildings() {
+		if conf := config.GetBuildingLevel(string(b.Type), b.Level); conf != nil {
+			boost += conf.RecruitSpeedBoost
+		}
+	}
+	return boost
+}
+
+// CalcRecruitTimePerUnit 计算单个士兵实际招募耗时（秒）
+func (c *City) CalcRecruitTimePerUnit(baseSeconds float64) float64 {
+	return applySpeedBoost(baseSeconds, c.GetRecruitSpeedBoost())
+}
+
+// RefreshRecruitTimes 按当前招募加速重新计算招募队列的单位耗时
+// 正在招募的单位保持剩余时间不变，新耗时从下一个单位开始生效
+func (c *City) RefreshRecruitTimes() {
+	for _, q := range c.RecruitQueue {
+		if q.BaseTimePerUnit <= 0 {
+			// 兼容旧快照：未记录基础耗时，从配置读取
+			if troopConf := config.GetTroopConfig(string(q.TroopType)); troopConf != nil {
+				q.BaseTimePerUnit = float64(troopConf.RecruitTimeSeconds)
+			} else {
+				q.BaseTimePerUnit = q.TimePerUnit
+			}
+		}
+		q.TimePerUnit = c.CalcRecruitTimePerUnit(q.BaseTimePerUnit)
+	}
+}
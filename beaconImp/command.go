@@ -0,0 +1,567 @@
+package beaconImp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"beacon/common"
+	"beacon/config"
+	"beacon/log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========== Command - 玩家操作命令 ==========
+//
+// 所有修改游戏状态的玩家操作都封装为命令：HTTP 处理函数只负责解析参数，
+// 由 execCommand 在写锁内执行命令，执行成功后追加到预写日志（见 wal.go），
+// 启动时按相同的 apply 逻辑重放快照之后的命令。
+// 命令只能依赖自身参数和当前游戏状态，不能依赖随机数或当前时间；游戏逻辑按固定步长推进（tickStep），
+// 并按ID顺序遍历城池和行军，因此重放时命令执行前的状态（包括新分配的战报、行军ID）与实际运行时一致。
+
+// CommandType 命令类型
+type CommandType string
+
+const (
+	CmdRegister               CommandType = "register"
+	CmdUpgradeBuilding        CommandType = "upgrade_building"
+	CmdCancelBuildingUpgrade  CommandType = "cancel_building_upgrade"
+	CmdReorderBuildingUpgrade CommandType = "reorder_building_upgrade"
+	CmdRecruit                CommandType = "recruit"
+	CmdCancelRecruit          CommandType = "cancel_recruit"
+	CmdReorderRecruit         CommandType = "reorder_recruit"
+	CmdStartResearch          CommandType = "start_research"
+	CmdSendMarch              CommandType = "send_march"
+	CmdRecallGarrison         CommandType = "recall_garrison"
+	CmdReadReport             CommandType = "read_report"
+	CmdDeleteReport           CommandType = "delete_report"
+)
+
+// gameCommand 命令参数，apply 成功时返回响应内容，失败时不能修改游戏状态
+// 注意：调用者需持有写锁
+type gameCommand interface {
+	commandType() CommandType
+	apply(B *Beacon, userID uint) (gin.H, error)
+}
+
+// commandFactories 命令类型 -> 空参数构造函数（重放时反序列化使用）
+var commandFactories = map[CommandType]func() gameCommand{
+	CmdRegister:               func() gameCommand { return &RegisterCmd{} },
+	CmdUpgradeBuilding:        func() gameCommand { return &UpgradeBuildingCmd{} },
+	CmdCancelBuildingUpgrade:  func() gameCommand { return &CancelBuildingUpgradeCmd{} },
+	CmdReorderBuildingUpgrade: func() gameCommand { return &ReorderBuildingUpgradeCmd{} },
+	CmdRecruit:                func() gameCommand { return &RecruitCmd{} },
+	CmdCancelRecruit:          func() gameCommand { return &CancelRecruitCmd{} },
+	CmdReorderRecruit:         func() gameCommand { return &ReorderRecruitCmd{} },
+	CmdStartResearch:          func() gameCommand { return &StartResearchCmd{} },
+	CmdSendMarch:              func() gameCommand { return &SendMarchCmd{} },
+	CmdRecallGarrison:         func() gameCommand { return &RecallGarrisonCmd{} },
+	CmdReadReport:             func() gameCommand { return &ReadReportCmd{} },
+	CmdDeleteReport:           func() gameCommand { return &DeleteReportCmd{} },
+}
+
+// CommandError 命令执行失败（携带返回给客户端的HTTP状态码）
+type CommandError struct {
+	Status  int
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+// commandError 创建带状态码的命令错误
+func commandError(status int, message string) error {
+	return &CommandError{Status: status, Message: message}
+}
+
+// execCommand 执行命令并返回响应
+func (B *Beacon) execCommand(c *gin.Context, userID uint, cmd gameCommand) {
+	resp, err := B.runCommand(userID, cmd)
+	if err != nil {
+		status := http.StatusBadRequest
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) {
+			status = cmdErr.Status
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// runCommand 在写锁内执行命令，成功后写入预写日志
+func (B *Beacon) runCommand(userID uint, cmd gameCommand) (gin.H, error) {
+	B.stateLock.Lock()
+	defer B.stateLock.Unlock()
+
+	resp, err := cmd.apply(B, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 状态已修改但命令未能落盘：不能向玩家确认成功，继续运行又会让下一次快照保存这条未确认的修改。
+	// 直接退出，重启后从快照和日志恢复到最后一条已落盘的命令
+	if err := B.appendCommand(userID, cmd); err != nil {
+		log.Fatalf("Failed to append command %s to wal: %v", cmd.commandType(), err)
+	}
+	return resp, nil
+}
+
+// decodeCommand 按类型反序列化命令参数
+func decodeCommand(cmdType CommandType, payload json.RawMessage) (gameCommand, error) {
+	factory, ok := commandFactories[cmdType]
+	if !ok {
+		return nil, errors.New("unknown command type: " + string(cmdType))
+	}
+	cmd := factory()
+	if err := json.Unmarshal(payload, cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// ownedCity 获取玩家拥有的城池，并标记为已修改（命令会修改该城池）
+func (B *Beacon) ownedCity(userID uint, cityID uint) (*City, error) {
+	city, err := B.state.GetCity(cityID)
+	if err != nil || city.UserID != userID {
+		return nil, commandError(http.StatusForbidden, "无权访问该城市")
+	}
+	B.state.MarkCityDirty(city.ID)
+	return city, nil
+}
+
+// ========== 注册 ==========
+
+// RegisterCmd 注册新玩家并创建初始城池（密码在命令外哈希，日志中不保存明文）
+type RegisterCmd struct {
+	Username       string `json:"username"`
+	HashedPassword string `json:"hashed_password"`
+}
+
+func (cmd *RegisterCmd) commandType() CommandType { return CmdRegister }
+
+func (cmd *RegisterCmd) apply(B *Beacon, _ uint) (gin.H, error) {
+	// 检查用户名是否存在
+	if _, err := B.state.GetUserByUsername(cmd.Username); err == nil {
+		return nil, errors.New("用户名已存在")
+	}
+
+	// 在地图上查找出生点（先于创建用户，失败时不留下无城池的用户）
+	posX, posY, err := B.state.WorldMap().FindSpawnPosition()
+	if err != nil {
+		return nil, commandError(http.StatusInternalServerError, "地图已满，无法创建城池")
+	}
+
+	// 创建用户
+	user := &User{Username: cmd.Username, Password: cmd.HashedPassword}
+	if err := B.state.CreateUser(user); err != nil {
+		return nil, commandError(http.StatusInternalServerError, "创建用户失败")
+	}
+
+	// 创建默认城池
+	city := &City{
+		UserID: user.ID,
+		Name:   "我的城池",
+		PosX:   posX,
+		PosY:   posY,
+		Wood:   5000,
+		Stone:  5000,
+		Iron:   3000,
+		Food:   5000,
+		Gold:   1000,
+	}
+
+	// 创建初始建筑（所有建筑初始等级至少为1）
+	city.initBuildings(1)
+
+	B.state.CreateCity(city)
+
+	log.Infof("New user registered: %s (ID=%d), city at (%d,%d)", cmd.Username, user.ID, posX, posY)
+	return gin.H{
+		"success": true,
+		"message": "注册成功",
+	}, nil
+}
+
+// newRegisterCmd 哈希密码并创建注册命令
+func newRegisterCmd(username, password string) (*RegisterCmd, error) {
+	hashedPassword, err := common.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return &RegisterCmd{Username: username, HashedPassword: hashedPassword}, nil
+}
+
+// ========== 建筑升级 ==========
+
+// UpgradeBuildingCmd 建筑升级加入队列
+type UpgradeBuildingCmd struct {
+	CityID       uint         `json:"city_id"`
+	BuildingType BuildingType `json:"building_type"`
+}
+
+func (cmd *UpgradeBuildingCmd) commandType() CommandType { return CmdUpgradeBuilding }
+
+func (cmd *UpgradeBuildingCmd) apply(B *Beacon, userID uint) (gin.H, error) {
+	city, err := B.ownedCity(userID, cmd.CityID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 不再检查队列是否为空，允许多个任务排队
+
+	// 查找指定类型的建筑
+	building := city.GetBuildingByType(cmd.BuildingType)
+	if building == nil {
+		return nil, commandError(http.StatusNotFound, "建筑不存在")
+	}
+
+	// 获取升级配置（同一建筑已在队列中时，在队列完成后的等级基础上再升一级）
+	targetLevel := city.plannedBuildingLevel(building) + 1
+	nextConf := config.GetBuildingLevel(string(building.Type), targetLevel)
+	if nextConf == nil {
+		return nil, errors.New("已达最高等级")
+	}
+
+	// 检查前置建筑
+	if ok, reason := city.CheckRequirements(nextConf.Requires); !ok {
+		return nil, errors.New(reason)
+	}
+
+	// 检查资源
+	if city.Wood < nextConf.UpgradeCostWood ||
+		city.Stone < nextConf.UpgradeCostStone ||
+		city.Iron < nextConf.UpgradeCostIron ||
+		city.Food < nextConf.UpgradeCostFood ||
+		city.Gold < nextConf.UpgradeCostGold {
+		return nil, errors.New("资源不足")
+	}
+
+	// 检查人口
+	if !B.CheckBuildingUpgradePopulation(city, building, targetLevel) {
+		return nil, errors.New("人口不足")
+	}
+
+	// 扣除资源
+	city.Wood -= nextConf.UpgradeCostWood
+	city.Stone -= nextConf.UpgradeCostStone
+	city.Iron -= nextConf.UpgradeCostIron
+	city.Food -= nextConf.UpgradeCostFood
+	city.Gold -= nextConf.UpgradeCostGold
+
+	// 创建升级队列并添加到队列中
+	queue := &BuildingUpgradeQueue{
+		BuildingType:   building.Type,
+		BuildingNameCN: GetBuildingNameCN(building.Type),
+		TargetLevel:    targetLevel,
+		RemainingTime:  city.CalcBuildingUpgradeTime(nextConf),
+		Cost:           buildingLevelCost(nextConf),
+	}
+	city.AddBuildingUpgradeToQueue(queue)
+
+	log.Infof("Building upgrade queued: city=%d, building=%s, level=%d->%d, time=%.0fs",
+		city.ID, queue.BuildingNameCN, queue.TargetLevel-1, queue.TargetLevel, queue.RemainingTime)
+
+	return gin.H{"success": true}, nil
+}
+
+// CancelBuildingUpgradeCmd 取消建筑升级
+type CancelBuildingUpgradeCmd struct {
+	CityID       uint         `json:"city_id"`
+	Index        int          `json:"index"`
+	BuildingType BuildingType `json:"building_type"`
+}
+
+func (cmd *CancelBuildingUpgradeCmd) commandType() CommandType { return CmdCancelBuildingUpgrade }
+
+func (cmd *CancelBuildingUpgradeCmd) apply(B *Beacon, userID uint) (gin.H, error) {
+	city, err := B.ownedCity(userID, cmd.CityID)
+	if err != nil {
+		return nil, err
+	}
+
+	refund, err := B.CancelBuildingUpgrade(city, cmd.Index, cmd.BuildingType)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"success": true,
+		"refund":  refund,
+	}, nil
+}
+
+// ReorderBuildingUpgradeCmd 调整建筑升级顺序
+type ReorderBuildingUpgradeCmd struct {
+	CityID       uint         `json:"city_id"`
+	Index        int          `json:"index"`
+	BuildingType BuildingType `json:"building_type"`
+	To           int          `json:"to"`
+}
+
+func (cmd *ReorderBuildingUpgradeCmd) commandType() CommandType { return CmdReorderBuildingUpgrade }
+
+func (cmd *ReorderBuildingUpgradeCmd) apply(B *Beacon, userID uint) (gin.H, error) {
+	city, err := B.ownedCity(userID, cmd.CityID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := B.ReorderBuildingUpgrade(city, cmd.Index, cmd.BuildingType, cmd.To); err != nil {
+		return nil, err
+	}
+
+	return gin.H{"success": true}, nil
+}
+
+// ========== 招募 ==========
+
+// RecruitCmd 招募士兵加入队列
+type RecruitCmd struct {
+	CityID    uint      `json:"city_id"`
+	TroopType TroopType `json:"troop_type"`
+	Quantity  int       `json:"quantity"`
+}
+
+func (cmd *RecruitCmd) commandType() CommandType { return CmdRecruit }
+
+func (cmd *RecruitCmd) apply(B *Beacon, userID uint) (gin.H, error) {
+	if cmd.Quantity <= 0 {
+		return nil, errors.New("数量必须大于0")
+	}
+
+	troopConf := config.GetTroopConfig(string(cmd.TroopType))
+	if troopConf == nil {
+		return nil, commandError(http.StatusNotFound, "兵种不存在")
+	}
+
+	city, err := B.ownedCity(userID, cmd.CityID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 不再检查队列是否为空，允许多个任务排队
+
+	// 检查兵种解锁条件
+	if ok, reason := city.CheckRequirements(troopConf.Requires); !ok {
+		return nil, errors.New(reason)
+	}
+
+	// 根据配置计算资源消耗
+	quantity := cmd.Quantity
+	costWood := quantity * troopConf.RecruitCostWood
+	costStone := quantity * troopConf.RecruitCostStone
+	costIron := quantity * troopConf.RecruitCostIron
+	costFood := quantity * troopConf.RecruitCostFood
+	if city.Wood < costWood || city.Stone < costStone ||
+		city.Iron < costIron || city.Food < costFood {
+		return nil, errors.New("资源不足")
+	}
+	if !B.CheckRecruitPopulation(city, troopConf, quantity) {
+		return nil, errors.New("人口不足")
+	}
+	city.Wood -= costWood
+	city.Stone -= costStone
+	city.Iron -= costIron
+	city.Food -= costFood
+
+	// 创建招募队列
+	baseTime := float64(troopConf.RecruitTimeSeconds)
+	timePerUnit := city.CalcRecruitTimePerUnit(baseTime)
+	queue := &RecruitQueue{
+		TroopType:       cmd.TroopType,
+		TroopNameCN:     troopConf.Name,
+		TotalQuantity:   quantity,
+		RemainingQty:    quantity,
+		TimePerUnit:     timePerUnit,
+		RemainingTime:   timePerUnit,
+		BaseTimePerUnit: baseTime,
+		CostPerUnit:     troopUnitCost(troopConf),
+	}
+	city.AddRecruitToQueue(queue)
+
+	log.Infof("Recruit queued: city=%d, type=%s, qty=%d, time_per_unit=%.0fs",
+		city.ID, queue.TroopNameCN, quantity, queue.TimePerUnit)
+
+	return gin.H{"success": true}, nil
+}
+
+// CancelRecruitCmd 取消招募
+type CancelRecruitCmd struct {
+	CityID    uint      `json:"city_id"`
+	Index     int       `json:"index"`
+	TroopType TroopType `json:"troop_type"`
+}
+
+func (cmd *CancelRecruitCmd) commandType() CommandType { return CmdCancelRecruit }
+
+func (cmd *CancelRecruitCmd) apply(B *Beacon, userID uint) (gin.H, error) {
+	city, err := B.ownedCity(userID, cmd.CityID)
+	if err != nil {
+		return nil, err
+	}
+
+	refund, err := B.CancelRecruit(city, cmd.Index, cmd.TroopType)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"success": true,
+		"refund":  refund,
+	}, nil
+}
+
+// ReorderRecruitCmd 调整招募顺序
+type ReorderRecruitCmd struct {
+	CityID    uint      `json:"city_id"`
+	Index     int       `json:"index"`
+	TroopType TroopType `json:"troop_type"`
+	To        int       `json:"to"`
+}
+
+func (cmd *ReorderRecruitCmd) commandType() CommandType { return CmdReorderRecruit }
+
+func (cmd *ReorderRecruitCmd) apply(B *Beacon, userID uint) (gin.H, error) {
+	city, err := B.ownedCity(userID, cmd.CityID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := B.ReorderRecruit(city, cmd.Index, cmd.TroopType, cmd.To); err != nil {
+		return nil, err
+	}
+
+	return gin.H{"success": true}, nil
+}
+
+// ========== 研究 ==========
+
+// StartResearchCmd 开始研究（资源从指定城池扣除）
+type StartResearchCmd struct {
+	CityID       uint         `json:"city_id"`
+	ResearchType ResearchType `json:"research_type"`
+}
+
+func (cmd *StartResearchCmd) commandType() CommandType { return CmdStartResearch }
+
+func (cmd *StartResearchCmd) apply(B *Beacon, userID uint) (gin.H, error) {
+	city, err := B.ownedCity(userID, cmd.CityID)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := B.StartResearch(city, cmd.ResearchType)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"success":  true,
+		"research": queue,
+	}, nil
+}
+
+// ========== 行军 ==========
+
+// SendMarchCmd 派出行军（运输时携带 Cargo）
+type SendMarchCmd struct {
+	CityID  uint      `json:"city_id"`
+	Type    MarchType `json:"type"`
+	TargetX int       `json:"target_x"`
+	TargetY int       `json:"target_y"`
+	Troops  []*Troop  `json:"troops"`
+	Cargo   Resources `json:"cargo"`
+}
+
+func (cmd *SendMarchCmd) commandType() CommandType { return CmdSendMarch }
+
+func (cmd *SendMarchCmd) apply(B *Beacon, userID uint) (gin.H, error) {
+	city, err := B.ownedCity(userID, cmd.CityID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 行军会持有部队，复制一份避免修改命令参数（日志中需保存原始参数）
+	troops := make([]*Troop, 0, len(cmd.Troops))
+	for _, t := range cmd.Troops {
+		troops = append(troops, &Troop{Type: t.Type, Quantity: t.Quantity})
+	}
+
+	var march *March
+	if cmd.Type == MarchTransport {
+		march, err = B.SendTransport(city, cmd.TargetX, cmd.TargetY, troops, cmd.Cargo)
+	} else {
+		march, err = B.SendMarch(city, cmd.Type, cmd.TargetX, cmd.TargetY, troops)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"success":  true,
+		"march_id": march.ID,
+	}, nil
+}
+
+// RecallGarrisonCmd 召回驻防部队
+type RecallGarrisonCmd struct {
+	CityID        uint `json:"city_id"`
+	StationCityID uint `json:"station_city_id"`
+}
+
+func (cmd *RecallGarrisonCmd) commandType() CommandType { return CmdRecallGarrison }
+
+func (cmd *RecallGarrisonCmd) apply(B *Beacon, userID uint) (gin.H, error) {
+	city, err := B.ownedCity(userID, cmd.CityID)
+	if err != nil {
+		return nil, err
+	}
+
+	march, err := B.RecallGarrison(city, cmd.StationCityID)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"success":  true,
+		"march_id": march.ID,
+	}, nil
+}
+
+// ========== 战报 ==========
+
+// ReadReportCmd 战报标记已读
+type ReadReportCmd struct {
+	ReportID uint `json:"report_id"`
+}
+
+func (cmd *ReadReportCmd) commandType() CommandType { return CmdReadReport }
+
+func (cmd *ReadReportCmd) apply(B *Beacon, userID uint) (gin.H, error) {
+	report, err := B.state.GetReport(userID, cmd.ReportID)
+	if err != nil {
+		return nil, commandError(http.StatusNotFound, "战报不存在")
+	}
+	report.Read = true
+	B.state.MarkReportsDirty(userID)
+
+	return gin.H{"success": true}, nil
+}
+
+// DeleteReportCmd 删除战报
+type DeleteReportCmd struct {
+	ReportID uint `json:"report_id"`
+}
+
+func (cmd *DeleteReportCmd) commandType() CommandType { return CmdDeleteReport }
+
+func (cmd *DeleteReportCmd) apply(B *Beacon, userID uint) (gin.H, error) {
+	if err := B.state.DeleteReport(userID, cmd.ReportID); err != nil {
+		return nil, commandError(http.StatusNotFound, "战报不存在")
+	}
+
+	return gin.H{"success": true}, nil
+}
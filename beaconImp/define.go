@@ -1,9 +1,12 @@
 package beaconImp
 
 import (
+	"os"
 	"sync"
 	"time"
 
+	"beacon/config"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -12,6 +15,9 @@ type Beacon struct {
 	r            *gin.Engine
 	stateLock    sync.RWMutex // 全局游戏状态读写锁
 	lastTickTime time.Time    // 上次tick时间（不持久化）
+	pendingTime  float64      // 尚未推进的实际时间（秒，不足一步，不持久化）
+	wal          *os.File     // 命令预写日志（见 wal.go）
+	storage      Storage      // 持久化后端（见 storage.go）
 }
 
 // ========== User ==========
@@ -21,6 +27,10 @@ type User struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	CityIDs  []uint `json:"city_ids"` // 玩家所有城池的ID列表
+
+	// 科技（对玩家所有城池和部队生效）
+	Research      map[ResearchType]int `json:"research"`       // 科技类型 -> 已研究等级
+	ResearchQueue []*ResearchQueue     `json:"research_queue"` // 研究队列（同一时间只执行第一个）
 }
 
 // ========== City（树型结构：包含建筑、部队、队列） ==========
@@ -44,18 +54,17 @@ type City struct {
 	StoneAcc float64 `json:"stone_acc"`
 	IronAcc  float64 `json:"iron_acc"`
 	FoodAcc  float64 `json:"food_acc"`
+	GoldAcc  float64 `json:"gold_acc"`
+
+	// 欠粮（粮食耗尽后未能支付的部队消耗，累积到一定数量士兵逃亡）
+	FoodDeficit int `json:"food_deficit"`
 
-	// 建筑（每个建筑独立成员）
-	Government *BaseBuilding `json:"government"`
-	Lumberyard *BaseBuilding `json:"lumberyard"`
-	Quarry     *BaseBuilding `json:"quarry"`
-	IronMine   *BaseBuilding `json:"iron_mine"`
-	Farm       *BaseBuilding `json:"farm"`
-	Warehouse  *BaseBuilding `json:"warehouse"`
-	Barracks   *BaseBuilding `json:"barracks"`
+	// 建筑（建筑类型 -> 建筑，类型由 conf/buildings.toml 定义）
+	Buildings map[BuildingType]*BaseBuilding `json:"buildings"`
 
 	// 部队
-	Troops []*Troop `json:"troops"`
+	Troops    []*Troop    `json:"troops"`    // 本城部队
+	Garrisons []*Garrison `json:"garrisons"` // 其他城池派驻到本城的部队
 
 	// 队列（允许多个任务排队，但同一时间只执行第一个）
 	BuildingUpgradeQueue []*BuildingUpgradeQueue `json:"building_upgrade_queue"`
@@ -64,17 +73,11 @@ type City struct {
 
 // ========== Building ==========
 
+// BuildingType 建筑类型，取值及属性由 conf/buildings.toml 定义
 type BuildingType string
 
-const (
-	BuildingGovernment BuildingType = "government"
-	BuildingLumberyard BuildingType = "lumberyard"
-	BuildingQuarry     BuildingType = "quarry"
-	BuildingIronMine   BuildingType = "iron_mine"
-	BuildingFarm       BuildingType = "farm"
-	BuildingWarehouse  BuildingType = "warehouse"
-	BuildingBarracks   BuildingType = "barracks"
-)
+// BuildingGovernment 官府（城池数量上限、税收加成等逻辑依赖该类型）
+const BuildingGovernment BuildingType = "government"
 
 // BaseBuilding 建筑基础结构（城池内部，无需ID）
 type BaseBuilding struct {
@@ -84,84 +87,142 @@ type BaseBuilding struct {
 
 // ========== Troop ==========
 
+// TroopType 兵种类型，取值及属性完全由 conf/troops.toml 定义
 type TroopType string
 
-const (
-	TroopSupplyCart    TroopType = "supply_cart"
-	TroopLightInfantry TroopType = "light_infantry"
-	TroopSpearman      TroopType = "spearman"
-	TroopArcher        TroopType = "archer"
-	TroopEliteInfantry TroopType = "elite_infantry"
-	TroopCrossbowman   TroopType = "crossbowman"
-	TroopLightCavalry  TroopType = "light_cavalry"
-	TroopHeavyInfantry TroopType = "heavy_infantry"
-	TroopHeavyCavalry  TroopType = "heavy_cavalry"
-	TroopMarksman      TroopType = "marksman"
-	TroopChariot       TroopType = "chariot"
-	TroopCatapult      TroopType = "catapult"
-)
-
 // Troop 士兵（城池内部，无需ID）
 type Troop struct {
 	Type     TroopType `json:"type"`
 	Quantity int       `json:"quantity"`
 }
 
+// Garrison 驻防部队（驻扎在其他城池，仍属于出发城池）
+// 驻防部队参与所在城池的防守，粮食和人口由出发城池承担
+type Garrison struct {
+	HomeCityID uint     `json:"home_city_id"`
+	UserID     uint     `json:"user_id"`
+	Troops     []*Troop `json:"troops"`
+}
+
 // ========== Queue Structs ==========
 
 // BuildingUpgradeQueue 建筑升级队列
 // 注意：使用相对剩余时间，避免服务停止期间时间推进
+// 注意：耗时在加入队列时按官府建造加速计算，之后官府升级不影响已排队的任务
 type BuildingUpgradeQueue struct {
 	BuildingType   BuildingType `json:"building_type"`    // 要升级的建筑类型
 	BuildingNameCN string       `json:"building_name_cn"` // 中文名称（前端显示）
 	TargetLevel    int          `json:"target_level"`     // 升到的等级
 	RemainingTime  float64      `json:"remaining_time"`   // 剩余时间（秒，浮点）
+	Cost           Resources    `json:"cost"`             // 已支付的资源（取消时按比例返还）
 }
 
 // RecruitQueue 招募队列
 // 注意：使用相对剩余时间和剩余数量，逐个完成
+// 注意：兵营升级完成后，单位耗时按新等级重新计算（从下一个单位开始生效）
 type RecruitQueue struct {
 	TroopType     TroopType `json:"troop_type"`
 	TroopNameCN   string    `json:"troop_name_cn"`  // 中文名称（前端显示）
 	TotalQuantity int       `json:"total_quantity"` // 总数量
 	RemainingQty  int       `json:"remaining_qty"`  // 剩余数量
-	TimePerUnit   float64   `json:"time_per_unit"`  // 单个招募时间（秒）
+	TimePerUnit   float64   `json:"time_per_unit"`  // 单个招募时间（秒，已计入兵营加速）
 	RemainingTime float64   `json:"remaining_time"` // 当前单位剩余时间（秒）
+
+	BaseTimePerUnit float64   `json:"base_time_per_unit"` // 单个招募基础时间（秒，未加速）
+	CostPerUnit     Resources `json:"cost_per_unit"`      // 单个士兵已支付的资源（取消时按未完成数量返还）
+}
+
+// ========== Research ==========
+
+// ResearchType 科技类型，取值及效果由 conf/research.toml 定义
+type ResearchType string
+
+// ResearchQueue 研究队列（属于玩家）
+// 注意：与建筑队列一样使用相对剩余时间
+type ResearchQueue struct {
+	ResearchType   ResearchType `json:"research_type"`
+	ResearchNameCN string       `json:"research_name_cn"` // 中文名称（前端显示）
+	TargetLevel    int          `json:"target_level"`     // 研究到的等级
+	CityID         uint         `json:"city_id"`          // 发起研究（支付资源）的城池
+	RemainingTime  float64      `json:"remaining_time"`   // 剩余时间（秒，浮点）
+}
+
+// ========== March ==========
+
+type MarchType string
+
+const (
+	MarchAttack    MarchType = "attack"    // 进攻
+	MarchSettle    MarchType = "settle"    // 拓荒（在空地建立新城池）
+	MarchTransport MarchType = "transport" // 运输（向己方城池运送资源）
+	MarchScout     MarchType = "scout"     // 侦察（刺探敌方城池情报）
+	MarchReinforce MarchType = "reinforce" // 增援（驻防到己方其他城池，召回时也使用此类型返回）
+)
+
+type MarchStatus string
+
+const (
+	MarchOutbound  MarchStatus = "outbound"  // 前往目标
+	MarchReturning MarchStatus = "returning" // 返回出发城池
+)
+
+// March 行军（离开城池的部队）
+// 注意：与队列一样使用相对剩余时间
+type March struct {
+	ID           uint        `json:"id"`
+	UserID       uint        `json:"user_id"`
+	OriginCityID uint        `json:"origin_city_id"` // 出发城池
+	Type         MarchType   `json:"type"`
+	Status       MarchStatus `json:"status"`
+	FromX        int         `json:"from_x"`
+	FromY        int         `json:"from_y"`
+	ToX          int         `json:"to_x"`
+	ToY          int         `json:"to_y"`
+	Troops       []*Troop    `json:"troops"`
+	Cargo        Resources   `json:"cargo"`      // 携带的资源（掠夺所得等）
+	Speed        float64     `json:"speed"`      // 行军速度（格/小时，取最慢兵种，计入科技加成）
+	TotalTime    float64     `json:"total_time"` // 单程总时间（秒）
+	EndsAt       float64     `json:"ends_at"`    // 当前阶段结束时的游戏时钟（秒）
+}
+
+// RemainingTime 当前阶段剩余时间（秒），elapsed 为当前游戏时钟
+func (m *March) RemainingTime(elapsed float64) float64 {
+	return max(m.EndsAt-elapsed, 0)
 }
 
 // ========== City Helper Methods ==========
 
 // GetBuildingByType 根据类型获取建筑指针
 func (c *City) GetBuildingByType(buildingType BuildingType) *BaseBuilding {
-	switch buildingType {
-	case BuildingGovernment:
-		return c.Government
-	case BuildingLumberyard:
-		return c.Lumberyard
-	case BuildingQuarry:
-		return c.Quarry
-	case BuildingIronMine:
-		return c.IronMine
-	case BuildingFarm:
-		return c.Farm
-	case BuildingWarehouse:
-		return c.Warehouse
-	case BuildingBarracks:
-		return c.Barracks
-	default:
-		return nil
-	}
+	return c.Buildings[buildingType]
 }
 
-// GetAllBuildings 获取所有建筑的列表（用于遍历）
+// GetAllBuildings 获取所有建筑的列表（按配置的显示顺序，用于遍历）
 func (c *City) GetAllBuildings() []*BaseBuilding {
-	return []*BaseBuilding{
-		c.Government,
-		c.Lumberyard,
-		c.Quarry,
-		c.IronMine,
-		c.Farm,
-		c.Warehouse,
-		c.Barracks,
+	buildings := make([]*BaseBuilding, 0, len(c.Buildings))
+	for _, buildingType := range config.ListBuildingTypes() {
+		if b := c.Buildings[BuildingType(buildingType)]; b != nil {
+			buildings = append(buildings, b)
+		}
+	}
+	return buildings
+}
+
+// initBuildings 为城池补齐配置中的所有建筑
+// 新建城池时创建全部建筑；加载快照时补齐配置新增的建筑类型，已有建筑不受影响。
+// 建筑等级取配置的初始等级，且不低于 minLevel。
+func (c *City) initBuildings(minLevel int) {
+	if c.Buildings == nil {
+		c.Buildings = make(map[BuildingType]*BaseBuilding)
+	}
+	for _, buildingType := range config.ListBuildingTypes() {
+		t := BuildingType(buildingType)
+		if c.Buildings[t] != nil {
+			continue
+		}
+		c.Buildings[t] = &BaseBuilding{
+			Type:  t,
+			Level: max(config.GetBuildingInitialLevel(buildingType), minLevel),
+		}
 	}
 }
@@ -0,0 +1,130 @@
+package beaconImp
+
+import "sort"
+
+// ========== Dirty Tracking - 变化追踪 ==========
+//
+// 修改玩家、城池、行军或战报持久化字段的地方需标记对应实体，保存快照时只编码和写入被标记的实体。
+// 标记点：
+//   - 命令：ownedCity（命令所操作的城池）、CreateUser/CreateCity、StartResearch、RecallGarrison、阅读战报
+//   - tick：资源（含小数累积）变化、建筑/招募/研究队列推进、行军到达目标城池或返回出发城池、断粮逃兵
+//   - 行军：CreateMarch、到达（onMarchArrive）、返回（onMarchReturn）、断粮逃兵
+//   - 战报：AddReport、DeleteReport、PruneReports（按玩家整体标记）
+//
+// 快照中的实体必须与同时保存的游戏时钟一致，否则命令日志会重放到从未出现过的状态上：
+// 资源的小数累积（WoodAcc 等）同样是持久化字段，变化时也要标记；行军只记录当前阶段结束时的游戏时钟，
+// 行进中不需要修改。
+// 只有计数器和游戏时钟属于全局数据，每次保存都会写入。
+
+// ChangeSet 上次保存之后变化的实体
+type ChangeSet struct {
+	Users   []uint // 玩家ID（升序）
+	Cities  []uint // 城池ID（升序）
+	Marches []uint // 行军ID（升序，可能已结束）
+	Reports []uint // 战报变化的玩家ID（升序）
+}
+
+// IsEmpty 是否没有实体变化
+func (cs *ChangeSet) IsEmpty() bool {
+	return len(cs.Users) == 0 && len(cs.Cities) == 0 && len(cs.Marches) == 0 && len(cs.Reports) == 0
+}
+
+// dirtySet 上次保存后修改过的实体（不持久化）
+type dirtySet struct {
+	users   map[uint]struct{}
+	cities  map[uint]struct{}
+	marches map[uint]struct{}
+	reports map[uint]struct{}
+}
+
+// MarkUserDirty 标记玩家已修改
+// 注意：调用者需持有写锁
+func (gs *GameState) MarkUserDirty(userID uint) {
+	if gs.dirty.users == nil {
+		gs.dirty.users = make(map[uint]struct{})
+	}
+	gs.dirty.users[userID] = struct{}{}
+}
+
+// MarkCityDirty 标记城池已修改
+// 注意：调用者需持有写锁
+func (gs *GameState) MarkCityDirty(cityID uint) {
+	if gs.dirty.cities == nil {
+		gs.dirty.cities = make(map[uint]struct{})
+	}
+	gs.dirty.cities[cityID] = struct{}{}
+}
+
+// MarkMarchDirty 标记行军已创建、修改或结束
+// 注意：调用者需持有写锁
+func (gs *GameState) MarkMarchDirty(marchID uint) {
+	if gs.dirty.marches == nil {
+		gs.dirty.marches = make(map[uint]struct{})
+	}
+	gs.dirty.marches[marchID] = struct{}{}
+}
+
+// MarkReportsDirty 标记玩家的战报列表已修改
+// 注意：调用者需持有写锁
+func (gs *GameState) MarkReportsDirty(userID uint) {
+	if gs.dirty.reports == nil {
+		gs.dirty.reports = make(map[uint]struct{})
+	}
+	gs.dirty.reports[userID] = struct{}{}
+}
+
+// markAllDirty 标记所有实体（加载后的兼容处理和存档迁移可能修改任意实体）
+func (gs *GameState) markAllDirty() {
+	for _, u := range gs.Users {
+		gs.MarkUserDirty(u.ID)
+	}
+	for id := range gs.Cities {
+		gs.MarkCityDirty(id)
+	}
+	for id := range gs.Marches {
+		gs.MarkMarchDirty(id)
+	}
+	for userID := range gs.Reports {
+		gs.MarkReportsDirty(userID)
+	}
+}
+
+// takeDirty 取出并清空变化集合
+// 注意：只由快照任务在读锁内调用（修改变化集合的其他代码都持有写锁，与之互斥）
+func (gs *GameState) takeDirty() *ChangeSet {
+	changes := &ChangeSet{
+		Users:   sortedIDs(gs.dirty.users),
+		Cities:  sortedIDs(gs.dirty.cities),
+		Marches: sortedIDs(gs.dirty.marches),
+		Reports: sortedIDs(gs.dirty.reports),
+	}
+	gs.dirty = dirtySet{}
+	return changes
+}
+
+// restoreDirty 保存失败时重新标记取出的实体
+// 注意：调用者需持有写锁，或是持有读锁的快照任务
+func (gs *GameState) restoreDirty(changes *ChangeSet) {
+	for _, id := range changes.Users {
+		gs.MarkUserDirty(id)
+	}
+	for _, id := range changes.Cities {
+		gs.MarkCityDirty(id)
+	}
+	for _, id := range changes.Marches {
+		gs.MarkMarchDirty(id)
+	}
+	for _, id := range changes.Reports {
+		gs.MarkReportsDirty(id)
+	}
+}
+
+// sortedIDs 取出以ID为键的映射的全部ID（升序）
+func sortedIDs[V any](set map[uint]V) []uint {
+	ids := make([]uint, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
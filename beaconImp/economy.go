@@ -0,0 +1,57 @@
+package beaconImp
+
+import "beacon/config"
+
+// ========== Production - 资源产出 ==========
+
+// CalcProductionPerHour 计算城池各资源每小时产量（按建筑配置的 produces 汇总，不含金币税收）
+func (c *City) CalcProductionPerHour() Resources {
+	var production Resources
+	for _, b := range c.GetAllBuildings() {
+		bConf := config.GetBuildingTypeConf(string(b.Type))
+		levelConf := config.GetBuildingLevel(string(b.Type), b.Level)
+		if bConf == nil || levelConf == nil {
+			continue
+		}
+		switch bConf.Produces {
+		case config.ResourceWood:
+			production.Wood += levelConf.ProductionPerHour
+		case config.ResourceStone:
+			production.Stone += levelConf.ProductionPerHour
+		case config.ResourceIron:
+			production.Iron += levelConf.ProductionPerHour
+		case config.ResourceFood:
+			production.Food += levelConf.ProductionPerHour
+		}
+	}
+	return production
+}
+
+// ========== Economy - 金币税收 ==========
+
+// CalcCivilianPopulation 计算城池平民人口（建筑当前等级占用的人口，士兵不纳税）
+func (c *City) CalcCivilianPopulation() int {
+	population := 0
+	for _, b := range c.GetAllBuildings() {
+		if b != nil {
+			population += buildingPopulation(b.Type, b.Level)
+		}
+	}
+	return population
+}
+
+// CalcGoldIncome 计算城池每小时金币收入
+func (c *City) CalcGoldIncome() float64 {
+	if config.EconomyConfig == nil {
+		return 0
+	}
+	tax := config.EconomyConfig.Tax
+
+	governmentLevel := 0
+	if government := c.GetBuildingByType(BuildingGovernment); government != nil {
+		governmentLevel = government.Level
+	}
+
+	income := tax.BaseGoldPerHour + float64(c.CalcCivilianPopulation())*tax.GoldPerPopulation
+	return income * (1 + float64(governmentLevel)*tax.GovernmentBonusPercent/100.0)
+}
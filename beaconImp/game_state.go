@@ -1,32 +1,92 @@
 package beaconImp
 
-import "errors"
+import (
+	"errors"
+
+	"beacon/config"
+	"beacon/log"
+)
 
 // ========== GameState - 树型结构 ==========
 
 // GameState 包含所有游戏数据
 // 架构：User -> City -> Buildings/Troops/Queues（树型包含）
-// 注意：玩家最近10秒内的操作可能在崩溃时丢失（设计权衡）
+// 注意：快照每10秒保存一次，期间的玩家操作记录在命令预写日志中，启动时重放（见 wal.go）
 // 注意：不记录绝对时间戳，避免服务停止期间时间推进
+// 注意：玩家、城池、行军和战报按实体分别保存（见 storage.go），编码 GameState 本身只得到计数器和游戏时钟
 type GameState struct {
-	NextUserID uint             `json:"next_user_id"`
-	NextCityID uint             `json:"next_city_id"`
-	Users      map[string]*User `json:"users"`  // username -> User
-	Cities     map[uint]*City   `json:"cities"` // cityID -> City
+	NextUserID     uint               `json:"next_user_id"`
+	NextCityID     uint               `json:"next_city_id"`
+	NextMarchID    uint               `json:"next_march_id"`
+	NextReportID   uint               `json:"next_report_id"`
+	LastCommandSeq uint64             `json:"last_command_seq"`  // 已执行的最后一条命令序号
+	Elapsed        float64            `json:"elapsed"`           // 游戏时钟：累计运行的tick秒数
+	Users          map[string]*User   `json:"users,omitempty"`   // username -> User
+	Cities         map[uint]*City     `json:"cities,omitempty"`  // cityID -> City
+	Marches        map[uint]*March    `json:"marches,omitempty"` // marchID -> March
+	Reports        map[uint][]*Report `json:"reports,omitempty"` // userID -> 战报列表
+
+	worldMap  *WorldMap      // 世界地图（由 Cities 重建，不持久化）
+	usersByID map[uint]*User // userID -> User（由 Users 重建，不持久化）
+	dirty     dirtySet       // 上次保存后修改过的实体（见 dirty.go，不持久化）
 }
 
 // NewGameState 创建初始空状态
 func NewGameState() *GameState {
 	return &GameState{
-		NextUserID: 1,
-		NextCityID: 1,
-		Users:      make(map[string]*User),
-		Cities:     make(map[uint]*City),
+		NextUserID:   1,
+		NextCityID:   1,
+		NextMarchID:  1,
+		NextReportID: 1,
+		Users:        make(map[string]*User),
+		Cities:       make(map[uint]*City),
+		Marches:      make(map[uint]*March),
+		Reports:      make(map[uint][]*Report),
+		worldMap:     NewWorldMap(mapWidth, mapHeight),
+		usersByID:    make(map[uint]*User),
+	}
+}
+
+// WorldMap 获取世界地图
+func (gs *GameState) WorldMap() *WorldMap {
+	return gs.worldMap
+}
+
+// RebuildWorldMap 根据城池坐标重建地图占用（加载快照后调用）
+// 坐标冲突或非法的旧城池会被迁移到新的出生点
+func (gs *GameState) RebuildWorldMap() {
+	gs.worldMap = NewWorldMap(mapWidth, mapHeight)
+
+	// 按ID顺序放置，保证先注册的城池保留原坐标
+	for id := uint(1); id < gs.NextCityID; id++ {
+		city, ok := gs.Cities[id]
+		if !ok {
+			continue
+		}
+		if err := gs.worldMap.Place(city.PosX, city.PosY, city.ID); err == nil {
+			continue
+		}
+		x, y, err := gs.worldMap.FindSpawnPosition()
+		if err != nil {
+			log.Errorf("Failed to relocate city %d: %v", city.ID, err)
+			continue
+		}
+		log.Warnf("City %d relocated: (%d,%d) -> (%d,%d)", city.ID, city.PosX, city.PosY, x, y)
+		city.PosX, city.PosY = x, y
+		gs.worldMap.Place(x, y, city.ID)
 	}
 }
 
 // ========== User Methods ==========
 
+// RebuildUserIndex 根据 Users 重建玩家ID索引（加载快照后调用）
+func (gs *GameState) RebuildUserIndex() {
+	gs.usersByID = make(map[uint]*User, len(gs.Users))
+	for _, u := range gs.Users {
+		gs.usersByID[u.ID] = u
+	}
+}
+
 // CreateUser 创建用户
 func (gs *GameState) CreateUser(u *User) error {
 	if _, exists := gs.Users[u.Username]; exists {
@@ -36,6 +96,8 @@ func (gs *GameState) CreateUser(u *User) error {
 	gs.NextUserID++
 	u.CityIDs = []uint{} // 初始化空城池列表
 	gs.Users[u.Username] = u
+	gs.usersByID[u.ID] = u
+	gs.MarkUserDirty(u.ID)
 	return nil
 }
 
@@ -48,32 +110,40 @@ func (gs *GameState) GetUserByUsername(username string) (*User, error) {
 	return u, nil
 }
 
+// GetUserByID 根据ID查询用户
+func (gs *GameState) GetUserByID(userID uint) (*User, error) {
+	u, ok := gs.usersByID[userID]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	return u, nil
+}
+
 // ========== City Methods ==========
 
-// CreateCity 创建城池
+// CreateCity 创建城池（坐标必须是地图上的空地）
 func (gs *GameState) CreateCity(c *City) error {
+	if !gs.worldMap.IsFree(c.PosX, c.PosY) {
+		return errors.New("position not available")
+	}
+
 	c.ID = gs.NextCityID
 	gs.NextCityID++
 
 	// 初始化城池内部结构
 	c.Troops = []*Troop{}
+	c.Garrisons = []*Garrison{}
 	c.BuildingUpgradeQueue = []*BuildingUpgradeQueue{}
 	c.RecruitQueue = []*RecruitQueue{}
 
-	// 查找城池所属用户
-	var user *User
-	for _, u := range gs.Users {
-		if u.ID == c.UserID {
-			user = u
-			break
-		}
-	}
-
 	gs.Cities[c.ID] = c
+	gs.worldMap.Place(c.PosX, c.PosY, c.ID)
+	gs.MarkCityDirty(c.ID)
 
 	// 添加到用户的城池列表
-	if user != nil {
+	if user, err := gs.GetUserByID(c.UserID); err == nil {
 		user.CityIDs = append(user.CityIDs, c.ID)
+		gs.MarkUserDirty(user.ID)
 	}
 
 	return nil
@@ -103,17 +173,31 @@ func (gs *GameState) ListCitiesByUser(userID uint) []*City {
 
 // AddTroop 向城池添加部队（自动合并同类型）
 func (c *City) AddTroop(troopType TroopType, quantity int) {
-	for _, t := range c.Troops {
-		if t.Type == troopType {
-			t.Quantity += quantity
-			return
+	c.Troops = addTroopTo(c.Troops, troopType, quantity)
+}
+
+// RemoveTroop 从城池移除部队（数量不足时返回错误，不做部分移除）
+func (c *City) RemoveTroop(troopType TroopType, quantity int) error {
+	t := c.GetTroop(troopType)
+	if t == nil || t.Quantity < quantity {
+		return errors.New("not enough troops")
+	}
+	t.Quantity -= quantity
+	if t.Quantity == 0 {
+		for i, troop := range c.Troops {
+			if troop == t {
+				c.Troops = append(c.Troops[:i], c.Troops[i+1:]...)
+				break
+			}
 		}
 	}
-	// 不存在则新建
-	c.Troops = append(c.Troops, &Troop{
-		Type:     troopType,
-		Quantity: quantity,
-	})
+	return nil
+}
+
+// HasTroopRole 兵种是否具有指定职能（config.TroopRoleXxx）
+func HasTroopRole(troopType TroopType, role string) bool {
+	troopConf := config.GetTroopConfig(string(troopType))
+	return troopConf != nil && troopConf.Role == role
 }
 
 // GetTroop 获取城池的指定类型部队
@@ -142,6 +226,8 @@ func (c *City) FinishCurrentBuildingUpgrade() {
 		if building != nil {
 			building.Level = queue.TargetLevel
 		}
+		// 建筑升级可能改变招募加速（兵营），影响后续士兵的招募耗时
+		c.RefreshRecruitTimes()
 		// 移除队列第一个元素
 		c.BuildingUpgradeQueue = c.BuildingUpgradeQueue[1:]
 	}
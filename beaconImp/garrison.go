@@ -0,0 +1,166 @@
+package beaconImp
+
+import (
+	"errors"
+
+	"beacon/log"
+)
+
+// ========== Garrison - 驻防 ==========
+//
+// 增援行军到达目标城池后成为驻防部队（City.Garrisons），与本城部队分开记录。
+// 驻防部队参与所在城池的防守，粮食消耗和人口仍计入出发城池。
+// 召回时驻防部队以返回状态的增援行军回到出发城池。
+
+// canReinforce 玩家是否可以向城池派驻部队（目前只允许己方城池）
+func canReinforce(userID uint, target *City) bool {
+	return target.UserID == userID
+}
+
+// validateReinforce 派出增援行军前的校验
+func (B *Beacon) validateReinforce(city *City, toX, toY int) error {
+	target, err := B.state.GetCity(B.state.WorldMap().CityAt(toX, toY))
+	if err != nil {
+		return errors.New("目标坐标没有城池")
+	}
+	if !canReinforce(city.UserID, target) {
+		return errors.New("只能增援己方城池")
+	}
+	return nil
+}
+
+// resolveReinforce 增援行军到达：部队驻防到目标城池
+func (B *Beacon) resolveReinforce(m *March) {
+	target, err := B.state.GetCity(B.state.WorldMap().CityAt(m.ToX, m.ToY))
+	if err != nil || !canReinforce(m.UserID, target) {
+		B.returnMarch(m)
+		return
+	}
+
+	garrison := target.GetGarrison(m.OriginCityID)
+	if garrison == nil {
+		garrison = &Garrison{HomeCityID: m.OriginCityID, UserID: m.UserID}
+		target.Garrisons = append(target.Garrisons, garrison)
+	}
+	for _, t := range m.Troops {
+		garrison.Troops = addTroopTo(garrison.Troops, t.Type, t.Quantity)
+	}
+	delete(B.state.Marches, m.ID)
+
+	log.Infof("Garrison stationed: march=%d, home_city=%d, city=%d", m.ID, m.OriginCityID, target.ID)
+}
+
+// RecallGarrison 召回驻扎在指定城池的部队
+// 注意：调用者需持有写锁
+func (B *Beacon) RecallGarrison(homeCity *City, stationCityID uint) (*March, error) {
+	stationCity, err := B.state.GetCity(stationCityID)
+	if err != nil {
+		return nil, errors.New("城池不存在")
+	}
+	garrison := stationCity.GetGarrison(homeCity.ID)
+	if garrison == nil {
+		return nil, errors.New("该城池没有本城的驻防部队")
+	}
+
+	speed, err := B.calcUserMarchSpeed(homeCity.UserID, garrison.Troops)
+	if err != nil {
+		return nil, errors.New("部队无法出征")
+	}
+	stationCity.RemoveGarrison(homeCity.ID)
+	B.state.MarkCityDirty(stationCity.ID)
+
+	// 召回行军直接处于返回状态：从驻防城池回到出发城池
+	travelTime := CalcMarchTime(stationCity.PosX, stationCity.PosY, homeCity.PosX, homeCity.PosY, speed)
+	m := &March{
+		UserID:       homeCity.UserID,
+		OriginCityID: homeCity.ID,
+		Type:         MarchReinforce,
+		Status:       MarchReturning,
+		FromX:        homeCity.PosX,
+		FromY:        homeCity.PosY,
+		ToX:          stationCity.PosX,
+		ToY:          stationCity.PosY,
+		Troops:       garrison.Troops,
+		Speed:        speed,
+		TotalTime:    travelTime,
+		EndsAt:       B.state.Elapsed + travelTime,
+	}
+	B.state.CreateMarch(m)
+
+	log.Infof("Garrison recalled: march=%d, home_city=%d, station_city=%d", m.ID, homeCity.ID, stationCity.ID)
+	return m, nil
+}
+
+// ListGarrisonsAbroad 获取城池派驻在其他城池的驻防部队（驻防城池ID -> 驻防）
+func (gs *GameState) ListGarrisonsAbroad(homeCityID uint) map[uint]*Garrison {
+	garrisons := make(map[uint]*Garrison)
+	for _, city := range gs.Cities {
+		if g := city.GetGarrison(homeCityID); g != nil {
+			garrisons[city.ID] = g
+		}
+	}
+	return garrisons
+}
+
+// GetGarrison 获取来自指定城池的驻防部队
+func (c *City) GetGarrison(homeCityID uint) *Garrison {
+	for _, g := range c.Garrisons {
+		if g.HomeCityID == homeCityID {
+			return g
+		}
+	}
+	return nil
+}
+
+// RemoveGarrison 移除来自指定城池的驻防部队
+func (c *City) RemoveGarrison(homeCityID uint) {
+	for i, g := range c.Garrisons {
+		if g.HomeCityID == homeCityID {
+			c.Garrisons = append(c.Garrisons[:i], c.Garrisons[i+1:]...)
+			return
+		}
+	}
+}
+
+// compactGarrisons 清理数量为0的驻防部队
+func (c *City) compactGarrisons() {
+	garrisons := make([]*Garrison, 0, len(c.Garrisons))
+	for _, g := range c.Garrisons {
+		g.Troops = compactTroops(g.Troops)
+		if len(g.Troops) > 0 {
+			garrisons = append(garrisons, g)
+		}
+	}
+	c.Garrisons = garrisons
+}
+
+// DefendingTroops 获取参与防守的所有部队（本城部队在前，驻防部队在后，返回原始指针）
+func (c *City) DefendingTroops() []*Troop {
+	troops := make([]*Troop, 0, len(c.Troops))
+	troops = append(troops, c.Troops...)
+	for _, g := range c.Garrisons {
+		troops = append(troops, g.Troops...)
+	}
+	return troops
+}
+
+// applyDefenderSurvivors 将战斗幸存数量回写到本城部队和驻防部队
+// survivors 必须与 DefendingTroops 的顺序一一对应
+func (c *City) applyDefenderSurvivors(survivors []*Troop) {
+	for i, t := range c.DefendingTroops() {
+		t.Quantity = survivors[i].Quantity
+	}
+	c.Troops = compactTroops(c.Troops)
+	c.compactGarrisons()
+}
+
+// addTroopTo 向部队列表添加部队（自动合并同类型）
+func addTroopTo(troops []*Troop, troopType TroopType, quantity int) []*Troop {
+	for _, t := range troops {
+		if t.Type == troopType {
+			t.Quantity += quantity
+			return troops
+		}
+	}
+	return append(troops, &Troop{Type: troopType, Quantity: quantity})
+}
@@ -4,6 +4,7 @@ import (
 	"beacon/common"
 	"beacon/config"
 	"beacon/log"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -64,6 +65,77 @@ func (B *Beacon) validateCityAccess(userID, cityID uint) (*City, error) {
 	return city, nil
 }
 
+// parseTroopsForm 从表单解析部队列表（troops[<troop_type>]=<quantity>）
+func parseTroopsForm(c *gin.Context) ([]*Troop, error) {
+	troopsForm := c.PostFormMap("troops")
+	if len(troopsForm) == 0 {
+		return nil, errors.New("未选择出征部队")
+	}
+
+	troops := make([]*Troop, 0, len(troopsForm))
+	for troopType, quantityStr := range troopsForm {
+		quantity, err := strconv.Atoi(quantityStr)
+		if err != nil || quantity < 0 {
+			return nil, errors.New("无效的部队数量")
+		}
+		if quantity == 0 {
+			continue
+		}
+		if config.GetTroopConfig(troopType) == nil {
+			return nil, errors.New("兵种不存在")
+		}
+		troops = append(troops, &Troop{Type: TroopType(troopType), Quantity: quantity})
+	}
+	if len(troops) == 0 {
+		return nil, errors.New("未选择出征部队")
+	}
+	return troops, nil
+}
+
+// parseCargoForm 从表单解析运输资源（cargo[<resource>]=<amount>）
+func parseCargoForm(c *gin.Context) (Resources, error) {
+	var cargo Resources
+	fields := map[string]*int{
+		"wood":  &cargo.Wood,
+		"stone": &cargo.Stone,
+		"iron":  &cargo.Iron,
+		"food":  &cargo.Food,
+		"gold":  &cargo.Gold,
+	}
+	for name, amountStr := range c.PostFormMap("cargo") {
+		field, ok := fields[name]
+		if !ok {
+			return cargo, errors.New("未知的资源类型")
+		}
+		amount, err := strconv.Atoi(amountStr)
+		if err != nil || amount < 0 {
+			return cargo, errors.New("无效的运输数量")
+		}
+		*field = amount
+	}
+	return cargo, nil
+}
+
+// TroopDisplay 部队展示信息
+type TroopDisplay struct {
+	Type     string `json:"type"`
+	NameCN   string `json:"name_cn"`
+	Quantity int    `json:"quantity"`
+}
+
+// toTroopDisplays 转换部队列表为展示信息
+func toTroopDisplays(troops []*Troop) []TroopDisplay {
+	var displays []TroopDisplay
+	for _, t := range troops {
+		displays = append(displays, TroopDisplay{
+			Type:     string(t.Type),
+			NameCN:   GetTroopNameCN(t.Type),
+			Quantity: t.Quantity,
+		})
+	}
+	return displays
+}
+
 func (B *Beacon) RegisterHttpHandler() {
 	// ========== API 路由组 ==========
 	api := B.r.Group("/api")
@@ -146,22 +218,33 @@ func (B *Beacon) RegisterHttpHandler() {
 			}
 
 			// 获取仓库容量
-			capacity := 0
-			if city.Warehouse != nil {
-				conf := config.GetBuildingLevel("warehouse", city.Warehouse.Level)
-				if conf != nil {
-					capacity = conf.Capacity
-				}
-			}
+			capacity := city.GetResourceCapacity()
+
+			// 粮食收支（每小时）和人口
+			B.stateLock.RLock()
+			foodProduction := B.calcCityFoodProduction(city)
+			foodUpkeep := B.calcCityFoodUpkeep(city)
+			populationUsed := B.CalcCityPopulationUsed(city)
+			populationCapacity := city.CalcPopulationCapacity()
+			goldIncome := city.CalcGoldIncome()
+			B.stateLock.RUnlock()
 
 			c.JSON(http.StatusOK, gin.H{
-				"city_id":  city.ID,
-				"wood":     city.Wood,
-				"stone":    city.Stone,
-				"iron":     city.Iron,
-				"food":     city.Food,
-				"gold":     city.Gold,
-				"capacity": capacity,
+				"city_id":         city.ID,
+				"wood":            city.Wood,
+				"stone":           city.Stone,
+				"iron":            city.Iron,
+				"food":            city.Food,
+				"gold":            city.Gold,
+				"capacity":        capacity,
+				"food_production": foodProduction,
+				"food_upkeep":     foodUpkeep,
+				"food_net":        foodProduction - foodUpkeep,
+				"food_deficit":    city.FoodDeficit,
+
+				"population_used":     populationUsed,
+				"population_capacity": populationCapacity,
+				"gold_income":         int(goldIncome),
 			})
 		})
 
@@ -183,24 +266,40 @@ func (B *Beacon) RegisterHttpHandler() {
 				return
 			}
 
-			type TroopDisplay struct {
-				Type     string `json:"type"`
-				NameCN   string `json:"name_cn"`
-				Quantity int    `json:"quantity"`
+			type GarrisonDisplay struct {
+				CityID   uint           `json:"city_id"` // 驻防部队的出发城池 / 本城部队的驻防城池
+				CityName string         `json:"city_name"`
+				Troops   []TroopDisplay `json:"troops"`
 			}
 
-			var troops []TroopDisplay
-			for _, t := range city.Troops {
-				troops = append(troops, TroopDisplay{
-					Type:     string(t.Type),
-					NameCN:   GetTroopNameCN(t.Type),
-					Quantity: t.Quantity,
-				})
+			B.stateLock.RLock()
+			defer B.stateLock.RUnlock()
+
+			// 其他城池派驻到本城的部队
+			garrisons := make([]GarrisonDisplay, 0, len(city.Garrisons))
+			for _, g := range city.Garrisons {
+				display := GarrisonDisplay{CityID: g.HomeCityID, Troops: toTroopDisplays(g.Troops)}
+				if homeCity, err := B.state.GetCity(g.HomeCityID); err == nil {
+					display.CityName = homeCity.Name
+				}
+				garrisons = append(garrisons, display)
+			}
+
+			// 本城派驻在其他城池的部队
+			stationedAbroad := make([]GarrisonDisplay, 0)
+			for stationCityID, g := range B.state.ListGarrisonsAbroad(city.ID) {
+				display := GarrisonDisplay{CityID: stationCityID, Troops: toTroopDisplays(g.Troops)}
+				if stationCity, err := B.state.GetCity(stationCityID); err == nil {
+					display.CityName = stationCity.Name
+				}
+				stationedAbroad = append(stationedAbroad, display)
 			}
 
 			c.JSON(http.StatusOK, gin.H{
-				"city_id": city.ID,
-				"troops":  troops,
+				"city_id":          city.ID,
+				"troops":           toTroopDisplays(city.Troops),
+				"garrisons":        garrisons,
+				"stationed_abroad": stationedAbroad,
 			})
 		})
 
@@ -290,34 +389,114 @@ func (B *Beacon) RegisterHttpHandler() {
 			})
 		})
 
-		// ========== 原子化API：单个建筑信息查询 ==========
-		// GET /api/building/government?city_id=1
-		api.GET("/building/government", func(c *gin.Context) {
-			B.getBuildingInfo(c, BuildingGovernment)
-		})
+		// ========== 取消建筑升级 ==========
+		// POST /api/building-queue/cancel
+		// Form: city_id, index（队列下标，从0开始）, building_type（用于校验队列未变化）
+		api.POST("/building-queue/cancel", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
 
-		api.GET("/building/lumberyard", func(c *gin.Context) {
-			B.getBuildingInfo(c, BuildingLumberyard)
-		})
+			cityID, err := parseCityID(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 city_id"})
+				return
+			}
+			index, err := strconv.Atoi(c.PostForm("index"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 index"})
+				return
+			}
 
-		api.GET("/building/quarry", func(c *gin.Context) {
-			B.getBuildingInfo(c, BuildingQuarry)
+			B.execCommand(c, userID, &CancelBuildingUpgradeCmd{
+				CityID:       cityID,
+				Index:        index,
+				BuildingType: BuildingType(c.PostForm("building_type")),
+			})
 		})
 
-		api.GET("/building/iron_mine", func(c *gin.Context) {
-			B.getBuildingInfo(c, BuildingIronMine)
+		// ========== 调整建筑升级顺序 ==========
+		// POST /api/building-queue/reorder
+		// Form: city_id, index, building_type, to（移动到的队列下标）
+		api.POST("/building-queue/reorder", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			cityID, err := parseCityID(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 city_id"})
+				return
+			}
+			index, errIndex := strconv.Atoi(c.PostForm("index"))
+			to, errTo := strconv.Atoi(c.PostForm("to"))
+			if errIndex != nil || errTo != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 index/to"})
+				return
+			}
+
+			B.execCommand(c, userID, &ReorderBuildingUpgradeCmd{
+				CityID:       cityID,
+				Index:        index,
+				BuildingType: BuildingType(c.PostForm("building_type")),
+				To:           to,
+			})
 		})
 
-		api.GET("/building/farm", func(c *gin.Context) {
-			B.getBuildingInfo(c, BuildingFarm)
+		// ========== 取消招募 ==========
+		// POST /api/recruit-queue/cancel
+		// Form: city_id, index（队列下标，从0开始）, troop_type（用于校验队列未变化）
+		api.POST("/recruit-queue/cancel", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			cityID, err := parseCityID(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 city_id"})
+				return
+			}
+			index, err := strconv.Atoi(c.PostForm("index"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 index"})
+				return
+			}
+
+			B.execCommand(c, userID, &CancelRecruitCmd{
+				CityID:    cityID,
+				Index:     index,
+				TroopType: TroopType(c.PostForm("troop_type")),
+			})
 		})
 
-		api.GET("/building/warehouse", func(c *gin.Context) {
-			B.getBuildingInfo(c, BuildingWarehouse)
+		// ========== 调整招募顺序 ==========
+		// POST /api/recruit-queue/reorder
+		// Form: city_id, index, troop_type, to（移动到的队列下标）
+		api.POST("/recruit-queue/reorder", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			cityID, err := parseCityID(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 city_id"})
+				return
+			}
+			index, errIndex := strconv.Atoi(c.PostForm("index"))
+			to, errTo := strconv.Atoi(c.PostForm("to"))
+			if errIndex != nil || errTo != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 index/to"})
+				return
+			}
+
+			B.execCommand(c, userID, &ReorderRecruitCmd{
+				CityID:    cityID,
+				Index:     index,
+				TroopType: TroopType(c.PostForm("troop_type")),
+				To:        to,
+			})
 		})
 
-		api.GET("/building/barracks", func(c *gin.Context) {
-			B.getBuildingInfo(c, BuildingBarracks)
+		// ========== 原子化API：单个建筑信息查询 ==========
+		// GET /api/building/government?city_id=1（建筑类型见 conf/buildings.toml）
+		api.GET("/building/:type", func(c *gin.Context) {
+			B.getBuildingInfo(c, BuildingType(c.Param("type")))
 		})
 
 		// ========== 原子化API：所有建筑列表 ==========
@@ -342,13 +521,17 @@ func (B *Beacon) RegisterHttpHandler() {
 				Type          string                    `json:"type"`
 				NameCN        string                    `json:"name_cn"`
 				Level         int                       `json:"level"`
+				PlannedLevel  int                       `json:"planned_level"` // 升级队列全部完成后的等级
 				CurrentEffect string                    `json:"current_effect"`
-				NextEffect    string                    `json:"next_effect"`
-				NextLevelConf *config.BuildingLevelConf `json:"next_level_conf"`
+				NextEffect    string                    `json:"next_effect"`     // 再升一级（planned_level+1）的效果
+				NextLevelConf *config.BuildingLevelConf `json:"next_level_conf"` // 再升一级的配置（已满级为 null）
+				UpgradeTime   float64                   `json:"upgrade_time"`    // 计入官府加速后的升级耗时（秒）
 				IsUpgrading   bool                      `json:"is_upgrading"`
+				Locked        bool                      `json:"locked"`                // 下一级是否未满足前置建筑
+				LockReason    string                    `json:"lock_reason,omitempty"` // 未满足的前置条件
 			}
 
-			displayBuildings := make([]BuildingDisplay, 0, 7)
+			displayBuildings := make([]BuildingDisplay, 0, len(city.Buildings))
 
 			// 检查哪些建筑在升级中
 			upgradingBuildings := make(map[BuildingType]bool)
@@ -361,13 +544,16 @@ func (B *Beacon) RegisterHttpHandler() {
 				if b == nil {
 					continue
 				}
+				// 下一次升级以队列完成后的等级为基础
+				plannedLevel := city.plannedBuildingLevel(b)
 				currentConf := config.GetBuildingLevel(string(b.Type), b.Level)
-				nextConf := config.GetBuildingLevel(string(b.Type), b.Level+1)
+				nextConf := config.GetBuildingLevel(string(b.Type), plannedLevel+1)
 
 				display := BuildingDisplay{
 					Type:          string(b.Type),
 					NameCN:        GetBuildingNameCN(b.Type),
 					Level:         b.Level,
+					PlannedLevel:  plannedLevel,
 					NextLevelConf: nextConf,
 					IsUpgrading:   upgradingBuildings[b.Type],
 				}
@@ -385,6 +571,9 @@ func (B *Beacon) RegisterHttpHandler() {
 				}
 
 				if nextConf != nil {
+					display.UpgradeTime = city.CalcBuildingUpgradeTime(nextConf)
+					ok, reason := city.CheckRequirements(nextConf.Requires)
+					display.Locked, display.LockReason = !ok, reason
 					if nextConf.ProductionPerHour > 0 {
 						display.NextEffect = strconv.Itoa(nextConf.ProductionPerHour) + "/小时"
 					} else if nextConf.Capacity > 0 {
@@ -423,166 +612,421 @@ func (B *Beacon) RegisterHttpHandler() {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 building_type"})
 				return
 			}
-			buildingType := BuildingType(buildingTypeStr)
 
-			B.stateLock.Lock()
-			defer B.stateLock.Unlock()
+			B.execCommand(c, userID, &UpgradeBuildingCmd{
+				CityID:       cityID,
+				BuildingType: BuildingType(buildingTypeStr),
+			})
+		})
+
+		// ========== 科技列表 ==========
+		// GET /api/research?city_id=1（前置建筑按该城池检查）
+		api.GET("/research", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			cityID, err := parseCityID(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 city_id"})
+				return
+			}
 
-			city, err := B.state.GetCity(cityID)
-			if err != nil || city.UserID != userID {
+			city, err := B.validateCityAccess(userID, cityID)
+			if err != nil {
 				c.JSON(http.StatusForbidden, gin.H{"error": "无权访问该城市"})
 				return
 			}
 
-			// 不再检查队列是否为空，允许多个任务排队
+			B.stateLock.RLock()
+			defer B.stateLock.RUnlock()
 
-			// 查找指定类型的建筑
-			building := city.GetBuildingByType(buildingType)
+			user, err := B.state.GetUserByID(userID)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+				return
+			}
 
-			if building == nil {
-				c.JSON(http.StatusNotFound, gin.H{"error": "建筑不存在"})
+			type ResearchDisplay struct {
+				Type          string                    `json:"type"`
+				NameCN        string                    `json:"name_cn"`
+				Effect        string                    `json:"effect"`
+				Level         int                       `json:"level"`
+				BonusPercent  int                       `json:"bonus_percent"`   // 当前等级的加成百分比
+				PlannedLevel  int                       `json:"planned_level"`   // 计入研究队列后的等级
+				NextLevelConf *config.ResearchLevelConf `json:"next_level_conf"` // 下一次研究的等级配置（已满级为 null）
+				Locked        bool                      `json:"locked"`
+				LockReason    string                    `json:"lock_reason,omitempty"`
+			}
+
+			researches := make([]ResearchDisplay, 0, len(config.ListResearchTypes()))
+			for _, researchType := range config.ListResearchTypes() {
+				t := ResearchType(researchType)
+				rConf := config.GetResearchTypeConf(researchType)
+				display := ResearchDisplay{
+					Type:         researchType,
+					NameCN:       GetResearchNameCN(t),
+					Effect:       rConf.Effect,
+					Level:        user.Research[t],
+					PlannedLevel: user.plannedResearchLevel(t),
+				}
+				if levelConf := config.GetResearchLevel(researchType, display.Level); levelConf != nil {
+					display.BonusPercent = levelConf.BonusPercent
+				}
+				if nextConf := config.GetResearchLevel(researchType, display.PlannedLevel+1); nextConf != nil {
+					display.NextLevelConf = nextConf
+					ok, reason := city.CheckRequirements(nextConf.Requires)
+					display.Locked, display.LockReason = !ok, reason
+				}
+				researches = append(researches, display)
+			}
+
+			researchQueue := user.ResearchQueue
+			if researchQueue == nil {
+				researchQueue = []*ResearchQueue{}
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"city_id":        city.ID,
+				"researches":     researches,
+				"research_queue": researchQueue,
+			})
+		})
+
+		// ========== 开始研究 ==========
+		// POST /api/research/start
+		// Form: city_id, research_type（资源从该城池扣除）
+		api.POST("/research/start", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			cityID, err := parseCityID(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 city_id"})
 				return
 			}
 
-			// 获取升级配置
-			nextConf := config.GetBuildingLevel(string(building.Type), building.Level+1)
-			if nextConf == nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "已达最高等级"})
+			researchType := c.PostForm("research_type")
+			if researchType == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 research_type"})
 				return
 			}
 
-			// 检查资源
-			if city.Wood < nextConf.UpgradeCostWood ||
-				city.Stone < nextConf.UpgradeCostStone ||
-				city.Iron < nextConf.UpgradeCostIron ||
-				city.Food < nextConf.UpgradeCostFood ||
-				city.Gold < nextConf.UpgradeCostGold {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "资源不足"})
+			B.execCommand(c, userID, &StartResearchCmd{
+				CityID:       cityID,
+				ResearchType: ResearchType(researchType),
+			})
+		})
+
+		// ========== 世界地图 ==========
+		// GET /api/map?x=100&y=100&radius=5
+		api.GET("/map", func(c *gin.Context) {
+			B.getMapView(c)
+		})
+
+		// ========== 派出行军 ==========
+		// POST /api/march/send
+		// Form: city_id, type(attack/settle/transport/scout/reinforce，默认 attack), target_x, target_y, troops[<troop_type>]=<quantity>
+		// 运输额外参数: cargo[wood|stone|iron|food|gold]=<amount>
+		api.POST("/march/send", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			cityID, err := parseCityID(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 city_id"})
 				return
 			}
 
-			// 扣除资源
-			city.Wood -= nextConf.UpgradeCostWood
-			city.Stone -= nextConf.UpgradeCostStone
-			city.Iron -= nextConf.UpgradeCostIron
-			city.Food -= nextConf.UpgradeCostFood
-			city.Gold -= nextConf.UpgradeCostGold
+			targetX, errX := strconv.Atoi(c.PostForm("target_x"))
+			targetY, errY := strconv.Atoi(c.PostForm("target_y"))
+			if errX != nil || errY != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的目标坐标"})
+				return
+			}
 
-			// 创建升级队列并添加到队列中
-			queue := &BuildingUpgradeQueue{
-				BuildingType:   building.Type,
-				BuildingNameCN: GetBuildingNameCN(building.Type),
-				TargetLevel:    building.Level + 1,
-				RemainingTime:  float64(nextConf.UpgradeTimeSeconds),
+			troops, err := parseTroopsForm(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
 			}
-			city.AddBuildingUpgradeToQueue(queue)
 
-			log.Infof("Building upgrade queued: city=%d, building=%s, level=%d->%d, time=%.0fs",
-				city.ID, queue.BuildingNameCN, building.Level, queue.TargetLevel, queue.RemainingTime)
+			cargo, err := parseCargoForm(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 
-			c.JSON(http.StatusOK, gin.H{"success": true})
+			B.execCommand(c, userID, &SendMarchCmd{
+				CityID:  cityID,
+				Type:    MarchType(c.DefaultPostForm("type", string(MarchAttack))),
+				TargetX: targetX,
+				TargetY: targetY,
+				Troops:  troops,
+				Cargo:   cargo,
+			})
 		})
 
-		// ========== 招募列表 ==========
-		// GET /api/recruit/list
-		api.GET("/recruit/list", func(c *gin.Context) {
-			// 转换为 TroopAttr 数组以获得正确的 JSON 标签
-			troops := make([]*config.TroopAttr, 0, len(config.TroopConfig.Troops))
-			for i := range config.TroopConfig.Troops {
-				t := &config.TroopConfig.Troops[i]
-				troops = append(troops, &config.TroopAttr{
-					Type:               t.Type,
-					Name:               t.Name,
-					MeleeAttack:        t.MeleeAttack,
-					RangedAttack:       t.RangedAttack,
-					MeleeDefense:       t.MeleeDefense,
-					RangedDefense:      t.RangedDefense,
-					Speed:              t.Speed,
-					Capacity:           t.Capacity,
-					FoodConsumption:    t.FoodConsumption,
-					RecruitTimeSeconds: t.RecruitTimeSeconds,
-					RecruitCostWood:    t.RecruitCostWood,
-					RecruitCostIron:    t.RecruitCostIron,
-					RecruitCostFood:    t.RecruitCostFood,
-					RecruitCostStone:   t.RecruitCostStone,
+		// ========== 召回驻防部队 ==========
+		// POST /api/garrison/recall
+		// Form: city_id（驻防部队的出发城池）, station_city_id（驻防所在城池）
+		api.POST("/garrison/recall", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			cityID, err := parseCityID(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 city_id"})
+				return
+			}
+
+			stationCityID, err := strconv.ParseUint(c.PostForm("station_city_id"), 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 station_city_id"})
+				return
+			}
+
+			B.execCommand(c, userID, &RecallGarrisonCmd{
+				CityID:        cityID,
+				StationCityID: uint(stationCityID),
+			})
+		})
+
+		// ========== 行军列表 ==========
+		// GET /api/marches
+		api.GET("/marches", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			type MarchDisplay struct {
+				ID            uint           `json:"id"`
+				OriginCityID  uint           `json:"origin_city_id"`
+				Type          string         `json:"type"`
+				Status        string         `json:"status"`
+				FromX         int            `json:"from_x"`
+				FromY         int            `json:"from_y"`
+				ToX           int            `json:"to_x"`
+				ToY           int            `json:"to_y"`
+				Troops        []TroopDisplay `json:"troops,omitempty"`
+				Cargo         *Resources     `json:"cargo,omitempty"`
+				TotalTime     float64        `json:"total_time"`
+				RemainingTime float64        `json:"remaining_time"`
+			}
+
+			B.stateLock.RLock()
+			defer B.stateLock.RUnlock()
+
+			marches := make([]MarchDisplay, 0)
+			for _, m := range B.state.ListMarchesByUser(userID) {
+				marches = append(marches, MarchDisplay{
+					ID:            m.ID,
+					OriginCityID:  m.OriginCityID,
+					Type:          string(m.Type),
+					Status:        string(m.Status),
+					FromX:         m.FromX,
+					FromY:         m.FromY,
+					ToX:           m.ToX,
+					ToY:           m.ToY,
+					Troops:        toTroopDisplays(m.Troops),
+					Cargo:         &m.Cargo,
+					TotalTime:     m.TotalTime,
+					RemainingTime: m.RemainingTime(B.state.Elapsed),
 				})
 			}
+
+			// 来袭的他人行军（不显示兵力）
+			incoming := make([]MarchDisplay, 0)
+			for _, city := range B.state.ListCitiesByUser(userID) {
+				for _, m := range B.state.ListIncomingMarches(city) {
+					incoming = append(incoming, MarchDisplay{
+						ID:            m.ID,
+						OriginCityID:  m.OriginCityID,
+						Type:          string(m.Type),
+						Status:        string(m.Status),
+						FromX:         m.FromX,
+						FromY:         m.FromY,
+						ToX:           m.ToX,
+						ToY:           m.ToY,
+						TotalTime:     m.TotalTime,
+						RemainingTime: m.RemainingTime(B.state.Elapsed),
+					})
+				}
+			}
+
 			c.JSON(http.StatusOK, gin.H{
-				"troops": troops,
+				"marches":  marches,
+				"incoming": incoming,
 			})
 		})
 
-		// ========== 招募确认 ==========
-		// POST /api/recruit/confirm
-		// Form: city_id, troop_type, quantity
-		api.POST("/recruit/confirm", func(c *gin.Context) {
+		// ========== 战报列表 ==========
+		// GET /api/reports
+		api.GET("/reports", func(c *gin.Context) {
 			userIDVal, _ := c.Get("userId")
 			userID := userIDVal.(uint)
 
-			cityID, err := parseCityID(c)
+			type ReportSummary struct {
+				ID          uint   `json:"id"`
+				Type        string `json:"type"`
+				Title       string `json:"title"`
+				CreatedAt   int64  `json:"created_at"`
+				Read        bool   `json:"read"`
+				AttackerWon bool   `json:"attacker_won"`
+			}
+
+			B.stateLock.RLock()
+			defer B.stateLock.RUnlock()
+
+			// 最新的战报排在前面
+			reports := B.state.ListReports(userID)
+			summaries := make([]ReportSummary, 0, len(reports))
+			unread := 0
+			for i := len(reports) - 1; i >= 0; i-- {
+				r := reports[i]
+				if !r.Read {
+					unread++
+				}
+				summaries = append(summaries, ReportSummary{
+					ID:          r.ID,
+					Type:        string(r.Type),
+					Title:       r.Title,
+					CreatedAt:   r.CreatedAt,
+					Read:        r.Read,
+					AttackerWon: r.AttackerWon,
+				})
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"reports":      summaries,
+				"unread_count": unread,
+			})
+		})
+
+		// ========== 战报详情 ==========
+		// GET /api/reports/:id
+		api.GET("/reports/:id", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			reportID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 city_id"})
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的战报ID"})
 				return
 			}
 
-			troopType := c.PostForm("troop_type")
-			quantityStr := c.PostForm("quantity")
-			quantity, _ := strconv.Atoi(quantityStr)
+			B.stateLock.RLock()
+			defer B.stateLock.RUnlock()
 
-			if quantity <= 0 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "数量必须大于0"})
+			report, err := B.state.GetReport(userID, uint(reportID))
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "战报不存在"})
 				return
 			}
 
-			troopConf := config.GetTroopConfig(troopType)
-			if troopConf == nil {
-				c.JSON(http.StatusNotFound, gin.H{"error": "兵种不存在"})
+			c.JSON(http.StatusOK, gin.H{
+				"report": report,
+			})
+		})
+
+		// ========== 战报标记已读 ==========
+		// POST /api/reports/:id/read
+		api.POST("/reports/:id/read", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			reportID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的战报ID"})
 				return
 			}
 
-			B.stateLock.Lock()
-			defer B.stateLock.Unlock()
+			B.execCommand(c, userID, &ReadReportCmd{ReportID: uint(reportID)})
+		})
 
-			city, err := B.state.GetCity(cityID)
-			if err != nil || city.UserID != userID {
-				c.JSON(http.StatusForbidden, gin.H{"error": "无权访问该城市"})
+		// ========== 删除战报 ==========
+		// POST /api/reports/:id/delete
+		api.POST("/reports/:id/delete", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			reportID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的战报ID"})
 				return
 			}
 
-			// 不再检查队列是否为空，允许多个任务排队
+			B.execCommand(c, userID, &DeleteReportCmd{ReportID: uint(reportID)})
+		})
 
-			// 根据配置计算资源消耗
-			costWood := quantity * troopConf.RecruitCostWood
-			costStone := quantity * troopConf.RecruitCostStone
-			costIron := quantity * troopConf.RecruitCostIron
-			costFood := quantity * troopConf.RecruitCostFood
-			//log.Infof("debug: %s %s %+v %d %d %d %d", troopType, GetTroopNameCN(TroopType(troopType)), troopConf, costWood, costStone, costIron, costFood)
-			if city.Wood < costWood || city.Stone < costStone ||
-				city.Iron < costIron || city.Food < costFood {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "资源不足"})
-				return
+		// ========== 招募列表 ==========
+		// GET /api/recruit/list?city_id=1（city_id 可选，用于计算兵营加速后的招募耗时和兵种解锁状态）
+		api.GET("/recruit/list", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			var city *City
+			if cityID, err := parseCityID(c); err == nil {
+				city, err = B.validateCityAccess(userID, cityID)
+				if err != nil {
+					c.JSON(http.StatusForbidden, gin.H{"error": "无权访问该城市"})
+					return
+				}
 			}
-			//log.Infof("debug: %d %d %d %d", city.Wood, city.Stone, city.Iron, city.Food)
-			city.Wood -= costWood
-			city.Stone -= costStone
-			city.Iron -= costIron
-			city.Food -= costFood
-			//log.Infof("+debug: %d %d %d %d", city.Wood, city.Stone, city.Iron, city.Food)
-			// 创建招募队列
-			queue := &RecruitQueue{
-				TroopType:     TroopType(troopType),
-				TroopNameCN:   troopConf.Name,
-				TotalQuantity: quantity,
-				RemainingQty:  quantity,
-				TimePerUnit:   float64(troopConf.RecruitTimeSeconds),
-				RemainingTime: float64(troopConf.RecruitTimeSeconds),
+
+			type RecruitTroopDisplay struct {
+				*config.TroopAttr
+				EffectiveRecruitTime float64 `json:"effective_recruit_time"` // 计入兵营加速后的单个招募耗时（秒）
+				Locked               bool    `json:"locked"`                 // 是否未满足解锁条件（需指定 city_id）
+				LockReason           string  `json:"lock_reason,omitempty"`  // 未解锁原因
 			}
-			city.AddRecruitToQueue(queue)
 
-			log.Infof("Recruit queued: city=%d, type=%s, qty=%d, time_per_unit=%.0fs",
-				city.ID, queue.TroopNameCN, quantity, queue.TimePerUnit)
+			B.stateLock.RLock()
+			defer B.stateLock.RUnlock()
+
+			recruitBoost := 0
+			if city != nil {
+				recruitBoost = city.GetRecruitSpeedBoost()
+			}
 
-			c.JSON(http.StatusOK, gin.H{"success": true})
+			troopConfs := config.ListTroopConfigs()
+			troops := make([]RecruitTroopDisplay, 0, len(troopConfs))
+			for _, attr := range troopConfs {
+				display := RecruitTroopDisplay{
+					TroopAttr:            attr,
+					EffectiveRecruitTime: applySpeedBoost(float64(attr.RecruitTimeSeconds), recruitBoost),
+				}
+				if city != nil {
+					ok, reason := city.CheckRequirements(attr.Requires)
+					display.Locked, display.LockReason = !ok, reason
+				}
+				troops = append(troops, display)
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"troops": troops,
+			})
+		})
+
+		// ========== 招募确认 ==========
+		// POST /api/recruit/confirm
+		// Form: city_id, troop_type, quantity
+		api.POST("/recruit/confirm", func(c *gin.Context) {
+			userIDVal, _ := c.Get("userId")
+			userID := userIDVal.(uint)
+
+			cityID, err := parseCityID(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的 city_id"})
+				return
+			}
+
+			troopType := c.PostForm("troop_type")
+			quantityStr := c.PostForm("quantity")
+			quantity, _ := strconv.Atoi(quantityStr)
+
+			B.execCommand(c, userID, &RecruitCmd{
+				CityID:    cityID,
+				TroopType: TroopType(troopType),
+				Quantity:  quantity,
+			})
 		})
 	}
 
@@ -627,6 +1071,10 @@ func (B *Beacon) RegisterHttpHandler() {
 		protected.GET("/map", func(c *gin.Context) {
 			c.File("./static/map.html")
 		})
+
+		protected.GET("/research", func(c *gin.Context) {
+			c.File("./static/research.html")
+		})
 	}
 
 	// ========== 认证相关 API ==========
@@ -634,19 +1082,8 @@ func (B *Beacon) RegisterHttpHandler() {
 		username := c.PostForm("username")
 		password := c.PostForm("password")
 
-		B.stateLock.Lock()
-		defer B.stateLock.Unlock()
-
-		// 检查用户名是否存在
-		if _, err := B.state.GetUserByUsername(username); err == nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "用户名已存在",
-			})
-			return
-		}
-
-		// 哈希密码
-		hashedPassword, err := common.HashPassword(password)
+		// 哈希密码（耗时操作，在锁外完成）
+		cmd, err := newRegisterCmd(username, password)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "密码哈希失败",
@@ -654,44 +1091,7 @@ func (B *Beacon) RegisterHttpHandler() {
 			return
 		}
 
-		// 创建用户
-		user := &User{Username: username, Password: hashedPassword}
-		if err := B.state.CreateUser(user); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "创建用户失败",
-			})
-			return
-		}
-
-		// 创建默认城池
-		city := &City{
-			UserID: user.ID,
-			Name:   "我的城池",
-			PosX:   100,
-			PosY:   100,
-			Wood:   5000,
-			Stone:  5000,
-			Iron:   3000,
-			Food:   5000,
-			Gold:   1000,
-		}
-
-		// 创建初始建筑（所有建筑初始等级1）
-		city.Government = &BaseBuilding{Type: BuildingGovernment, Level: 1}
-		city.Lumberyard = &BaseBuilding{Type: BuildingLumberyard, Level: 1}
-		city.Quarry = &BaseBuilding{Type: BuildingQuarry, Level: 1}
-		city.IronMine = &BaseBuilding{Type: BuildingIronMine, Level: 1}
-		city.Farm = &BaseBuilding{Type: BuildingFarm, Level: 1}
-		city.Warehouse = &BaseBuilding{Type: BuildingWarehouse, Level: 1}
-		city.Barracks = &BaseBuilding{Type: BuildingBarracks, Level: 1}
-
-		B.state.CreateCity(city)
-
-		log.Infof("New user registered: %s (ID=%d)", username, user.ID)
-		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"message": "注册成功",
-		})
+		B.execCommand(c, 0, cmd)
 	})
 
 	B.r.POST("/api/login", func(c *gin.Context) {
@@ -768,8 +1168,10 @@ func (B *Beacon) getBuildingInfo(c *gin.Context, buildingType BuildingType) {
 		return
 	}
 
+	// 下一次升级以队列完成后的等级为基础
+	plannedLevel := city.plannedBuildingLevel(building)
 	currentConf := config.GetBuildingLevel(string(building.Type), building.Level)
-	nextConf := config.GetBuildingLevel(string(building.Type), building.Level+1)
+	nextConf := config.GetBuildingLevel(string(building.Type), plannedLevel+1)
 
 	// 检查是否在升级中
 	isUpgrading := false
@@ -781,23 +1183,130 @@ func (B *Beacon) getBuildingInfo(c *gin.Context, buildingType BuildingType) {
 	}
 
 	type BuildingInfo struct {
-		Type        string                    `json:"type"`
-		NameCN      string                    `json:"name_cn"`
-		Level       int                       `json:"level"`
-		CurrentConf *config.BuildingLevelConf `json:"current_conf"`
-		NextConf    *config.BuildingLevelConf `json:"next_conf"`
-		IsUpgrading bool                      `json:"is_upgrading"`
+		Type         string                    `json:"type"`
+		NameCN       string                    `json:"name_cn"`
+		Level        int                       `json:"level"`
+		PlannedLevel int                       `json:"planned_level"` // 升级队列全部完成后的等级
+		CurrentConf  *config.BuildingLevelConf `json:"current_conf"`
+		NextConf     *config.BuildingLevelConf `json:"next_conf"`    // 再升一级（planned_level+1）的配置
+		UpgradeTime  float64                   `json:"upgrade_time"` // 计入官府加速后的升级耗时（秒）
+		IsUpgrading  bool                      `json:"is_upgrading"`
+		Locked       bool                      `json:"locked"`                // 下一级是否未满足前置建筑
+		LockReason   string                    `json:"lock_reason,omitempty"` // 未满足的前置条件
+	}
+
+	upgradeTime := 0.0
+	locked, lockReason := false, ""
+	if nextConf != nil {
+		upgradeTime = city.CalcBuildingUpgradeTime(nextConf)
+		ok, reason := city.CheckRequirements(nextConf.Requires)
+		locked, lockReason = !ok, reason
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"city_id": city.ID,
 		"building": BuildingInfo{
-			Type:        string(building.Type),
-			NameCN:      GetBuildingNameCN(building.Type),
-			Level:       building.Level,
-			CurrentConf: currentConf,
-			NextConf:    nextConf,
-			IsUpgrading: isUpgrading,
+			Type:         string(building.Type),
+			NameCN:       GetBuildingNameCN(building.Type),
+			Level:        building.Level,
+			PlannedLevel: plannedLevel,
+			CurrentConf:  currentConf,
+			NextConf:     nextConf,
+			UpgradeTime:  upgradeTime,
+			IsUpgrading:  isUpgrading,
+			Locked:       locked,
+			LockReason:   lockReason,
 		},
 	})
 }
+
+// getMapView 查询以 (x, y) 为中心、半径为 radius 的地图视野
+func (B *Beacon) getMapView(c *gin.Context) {
+	centerX, errX := strconv.Atoi(c.Query("x"))
+	centerY, errY := strconv.Atoi(c.Query("y"))
+	if errX != nil || errY != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少或无效的坐标"})
+		return
+	}
+
+	radius := 5
+	if radiusStr := c.Query("radius"); radiusStr != "" {
+		r, err := strconv.Atoi(radiusStr)
+		if err != nil || r < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 radius"})
+			return
+		}
+		radius = r
+	}
+	if radius > maxMapViewRadius {
+		radius = maxMapViewRadius
+	}
+
+	type MapCity struct {
+		ID        uint   `json:"id"`
+		Name      string `json:"name"`
+		PosX      int    `json:"pos_x"`
+		PosY      int    `json:"pos_y"`
+		OwnerID   uint   `json:"owner_id"`
+		OwnerName string `json:"owner_name"`
+	}
+
+	type MapOwner struct {
+		ID       uint   `json:"id"`
+		Username string `json:"username"`
+	}
+
+	B.stateLock.RLock()
+	defer B.stateLock.RUnlock()
+
+	worldMap := B.state.WorldMap()
+	tiles := make([]Tile, 0, (2*radius+1)*(2*radius+1))
+	cities := make([]MapCity, 0)
+	owners := make(map[uint]MapOwner)
+
+	for y := centerY - radius; y <= centerY+radius; y++ {
+		for x := centerX - radius; x <= centerX+radius; x++ {
+			if !worldMap.InBounds(x, y) {
+				continue
+			}
+			tile := worldMap.TileAt(x, y)
+			tiles = append(tiles, tile)
+
+			if tile.CityID == 0 {
+				continue
+			}
+			city, err := B.state.GetCity(tile.CityID)
+			if err != nil {
+				continue
+			}
+			mapCity := MapCity{
+				ID:      city.ID,
+				Name:    city.Name,
+				PosX:    city.PosX,
+				PosY:    city.PosY,
+				OwnerID: city.UserID,
+			}
+			if owner, err := B.state.GetUserByID(city.UserID); err == nil {
+				mapCity.OwnerName = owner.Username
+				owners[owner.ID] = MapOwner{ID: owner.ID, Username: owner.Username}
+			}
+			cities = append(cities, mapCity)
+		}
+	}
+
+	ownerList := make([]MapOwner, 0, len(owners))
+	for _, owner := range owners {
+		ownerList = append(ownerList, owner)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"x":          centerX,
+		"y":          centerY,
+		"radius":     radius,
+		"map_width":  worldMap.Width,
+		"map_height": worldMap.Height,
+		"tiles":      tiles,
+		"cities":     cities,
+		"owners":     ownerList,
+	})
+}
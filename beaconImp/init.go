@@ -15,11 +15,18 @@ func (B *Beacon) Init() {
 	}
 	log.Info("Config loaded successfully")
 
-	// 创建快照目录
-	if err := os.MkdirAll(snapshotDir, 0755); err != nil {
-		log.Fatal("Failed to create snapshot dir:", err)
+	// 创建数据目录
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		log.Fatal("Failed to create data dir:", err)
 	}
 
+	// 创建存储后端
+	storage, err := newStorage()
+	if err != nil {
+		log.Fatal("Failed to open storage:", err)
+	}
+	B.storage = storage
+
 	// 加载最新快照到内存
 	if err := B.LoadLatestSnapshot(); err != nil {
 		log.Fatal("Failed to load snapshot:", err)
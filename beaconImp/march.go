@@ -0,0 +1,291 @@
+package beaconImp
+
+import (
+	"errors"
+	"math"
+
+	"beacon/config"
+	"beacon/log"
+)
+
+// ========== March - 行军 ==========
+
+const (
+	marchSecondsPerHour = 3600.0 // 兵种 Speed 单位：格/小时
+	minMarchSeconds     = 1.0    // 最短行军时间（秒）
+)
+
+// CalcMarchSpeed 计算部队行军速度（取最慢兵种的速度）
+func CalcMarchSpeed(troops []*Troop) (int, error) {
+	speed := 0
+	for _, t := range troops {
+		troopConf := config.GetTroopConfig(string(t.Type))
+		if troopConf == nil {
+			return 0, errors.New("unknown troop type: " + string(t.Type))
+		}
+		if troopConf.Speed <= 0 {
+			return 0, errors.New("troop cannot march: " + string(t.Type))
+		}
+		if speed == 0 || troopConf.Speed < speed {
+			speed = troopConf.Speed
+		}
+	}
+	if speed == 0 {
+		return 0, errors.New("no troops")
+	}
+	return speed, nil
+}
+
+// CalcMarchTime 计算单程行军时间（秒）
+func CalcMarchTime(fromX, fromY, toX, toY int, speed float64) float64 {
+	seconds := Distance(fromX, fromY, toX, toY) * marchSecondsPerHour / speed
+	return math.Max(seconds, minMarchSeconds)
+}
+
+// CreateMarch 创建行军（分配ID并加入状态）
+func (gs *GameState) CreateMarch(m *March) {
+	m.ID = gs.NextMarchID
+	gs.NextMarchID++
+	gs.Marches[m.ID] = m
+	gs.MarkMarchDirty(m.ID)
+}
+
+// GetMarch 获取行军
+func (gs *GameState) GetMarch(marchID uint) (*March, error) {
+	m, ok := gs.Marches[marchID]
+	if !ok {
+		return nil, errors.New("march not found")
+	}
+	return m, nil
+}
+
+// ListMarchesByUser 获取用户所有行军
+func (gs *GameState) ListMarchesByUser(userID uint) []*March {
+	marches := make([]*March, 0)
+	for _, m := range gs.Marches {
+		if m.UserID == userID {
+			marches = append(marches, m)
+		}
+	}
+	return marches
+}
+
+// ListIncomingMarches 获取前往指定城池的他人行军
+func (gs *GameState) ListIncomingMarches(city *City) []*March {
+	marches := make([]*March, 0)
+	for _, m := range gs.Marches {
+		if m.Status == MarchOutbound && m.UserID != city.UserID &&
+			m.ToX == city.PosX && m.ToY == city.PosY {
+			marches = append(marches, m)
+		}
+	}
+	return marches
+}
+
+// SendMarch 从城池派出部队前往目标坐标
+// 注意：调用者需持有写锁
+func (B *Beacon) SendMarch(city *City, marchType MarchType, toX, toY int, troops []*Troop) (*March, error) {
+	if !B.state.WorldMap().InBounds(toX, toY) {
+		return nil, errors.New("目标坐标超出地图范围")
+	}
+	if toX == city.PosX && toY == city.PosY {
+		return nil, errors.New("不能以自身城池为目标")
+	}
+
+	// 同一兵种的多个条目合并后再检查兵力是否充足
+	var merged []*Troop
+	for _, t := range troops {
+		if t.Quantity <= 0 {
+			return nil, errors.New("出征数量必须大于0")
+		}
+		merged = addTroopTo(merged, t.Type, t.Quantity)
+	}
+	troops = merged
+	for _, t := range troops {
+		if cityTroop := city.GetTroop(t.Type); cityTroop == nil || cityTroop.Quantity < t.Quantity {
+			return nil, errors.New("兵力不足: " + GetTroopNameCN(t.Type))
+		}
+	}
+
+	switch marchType {
+	case MarchAttack:
+	case MarchSettle:
+		if err := B.validateSettle(city, toX, toY, troops); err != nil {
+			return nil, err
+		}
+	case MarchTransport:
+		if err := B.validateTransport(city, toX, toY, troops); err != nil {
+			return nil, err
+		}
+	case MarchScout:
+		if err := B.validateScout(city, toX, toY, troops); err != nil {
+			return nil, err
+		}
+	case MarchReinforce:
+		if err := B.validateReinforce(city, toX, toY); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("未知的行军类型")
+	}
+
+	speed, err := B.calcUserMarchSpeed(city.UserID, troops)
+	if err != nil {
+		log.Warnf("Invalid march troops: city=%d, err=%v", city.ID, err)
+		return nil, errors.New("部队无法出征")
+	}
+
+	// 从城池扣除出征部队
+	for _, t := range troops {
+		if err := city.RemoveTroop(t.Type, t.Quantity); err != nil {
+			return nil, errors.New("兵力不足: " + GetTroopNameCN(t.Type))
+		}
+	}
+
+	travelTime := CalcMarchTime(city.PosX, city.PosY, toX, toY, speed)
+	m := &March{
+		UserID:       city.UserID,
+		OriginCityID: city.ID,
+		Type:         marchType,
+		Status:       MarchOutbound,
+		FromX:        city.PosX,
+		FromY:        city.PosY,
+		ToX:          toX,
+		ToY:          toY,
+		Troops:       troops,
+		Speed:        speed,
+		TotalTime:    travelTime,
+		EndsAt:       B.state.Elapsed + travelTime,
+	}
+	B.state.CreateMarch(m)
+
+	log.Infof("March sent: id=%d, city=%d, type=%s, (%d,%d)->(%d,%d), speed=%.1f, time=%.0fs",
+		m.ID, city.ID, m.Type, m.FromX, m.FromY, m.ToX, m.ToY, m.Speed, m.TotalTime)
+	return m, nil
+}
+
+// processMarches 推进所有行军（持有写锁时调用）
+// 按ID顺序结算，同一目标的多支行军的战斗顺序和战报ID固定，命令重放时结果一致
+func (B *Beacon) processMarches(deltaSeconds float64) {
+	for _, id := range sortedIDs(B.state.Marches) {
+		m := B.state.Marches[id]
+		if m.EndsAt > B.state.Elapsed {
+			continue
+		}
+
+		switch m.Status {
+		case MarchOutbound:
+			B.onMarchArrive(m)
+		case MarchReturning:
+			B.onMarchReturn(m)
+		}
+	}
+}
+
+// onMarchArrive 行军到达目标
+func (B *Beacon) onMarchArrive(m *March) {
+	log.Infof("March arrived: id=%d, type=%s, target=(%d,%d)", m.ID, m.Type, m.ToX, m.ToY)
+
+	// 到达结算会修改行军（伤亡、携带资源、返程）或结束行军
+	B.state.MarkMarchDirty(m.ID)
+
+	// 到达结算可能修改目标城池（战斗、掠夺、运输、驻防）
+	if cityID := B.state.WorldMap().CityAt(m.ToX, m.ToY); cityID != 0 {
+		B.state.MarkCityDirty(cityID)
+	}
+
+	switch m.Type {
+	case MarchAttack:
+		B.resolveAttack(m)
+	case MarchSettle:
+		B.resolveSettle(m)
+	case MarchTransport:
+		B.resolveTransport(m)
+	case MarchScout:
+		B.resolveScout(m)
+	case MarchReinforce:
+		B.resolveReinforce(m)
+	default:
+		B.returnMarch(m)
+	}
+}
+
+// resolveAttack 进攻行军到达：与目标城池的本城部队和驻防部队交战
+func (B *Beacon) resolveAttack(m *March) {
+	cityID := B.state.WorldMap().CityAt(m.ToX, m.ToY)
+	target, err := B.state.GetCity(cityID)
+	if err != nil || target.UserID == m.UserID {
+		// 目标为空地或己方城池，直接返回
+		B.returnMarch(m)
+		return
+	}
+
+	defenders := target.DefendingTroops()
+	attackerTroops := copyTroops(m.Troops)
+	defenderTroops := copyTroops(defenders)
+
+	result := ResolveBattle(m.Troops, defenders, B.researchTroopStats(m.UserID), B.researchTroopStats(target.UserID))
+	target.applyDefenderSurvivors(result.DefenderSurvivors)
+	m.Troops = compactTroops(result.AttackerSurvivors)
+
+	// 进攻方胜利：幸存部队按负重掠夺资源
+	var loot Resources
+	if result.AttackerWon {
+		loot = PlunderCity(target, B.calcUserCarryCapacity(m.UserID, m.Troops))
+		m.Cargo = loot
+	}
+
+	B.addBattleReports(m, target, attackerTroops, defenderTroops, result, loot)
+
+	log.Infof("Battle resolved: march=%d, target_city=%d, attacker_won=%v, attacker_losses=%v, defender_losses=%v, loot=%+v",
+		m.ID, target.ID, result.AttackerWon, result.AttackerLosses, result.DefenderLosses, loot)
+
+	if len(m.Troops) == 0 {
+		// 进攻部队全军覆没
+		delete(B.state.Marches, m.ID)
+		return
+	}
+	B.returnMarch(m)
+}
+
+// returnMarch 行军掉头返回出发城池（到达结算时调用，从到达时刻开始返程）
+func (B *Beacon) returnMarch(m *March) {
+	m.Status = MarchReturning
+	m.EndsAt += m.TotalTime
+}
+
+// compactTroops 移除数量为0的部队
+func compactTroops(troops []*Troop) []*Troop {
+	compacted := make([]*Troop, 0, len(troops))
+	for _, t := range troops {
+		if t.Quantity > 0 {
+			compacted = append(compacted, t)
+		}
+	}
+	return compacted
+}
+
+// onMarchReturn 行军返回出发城池，部队归建
+func (B *Beacon) onMarchReturn(m *March) {
+	delete(B.state.Marches, m.ID)
+	B.state.MarkMarchDirty(m.ID)
+
+	city, err := B.state.GetCity(m.OriginCityID)
+	if err != nil {
+		log.Warnf("March %d returned to missing city %d, troops lost", m.ID, m.OriginCityID)
+		return
+	}
+	B.state.MarkCityDirty(city.ID)
+	for _, t := range m.Troops {
+		if t.Quantity > 0 {
+			city.AddTroop(t.Type, t.Quantity)
+		}
+	}
+
+	// 卸下携带的资源（超出仓库容量的部分作废）
+	if !m.Cargo.IsEmpty() {
+		city.AddResources(m.Cargo)
+		B.applyCityResourceCap(city)
+	}
+	log.Infof("March returned: id=%d, city=%d, cargo=%+v", m.ID, city.ID, m.Cargo)
+}
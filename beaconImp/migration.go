@@ -0,0 +1,257 @@
+package beaconImp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"beacon/log"
+)
+
+// ========== Schema Migration - 存档结构版本迁移 ==========
+//
+// 快照中的实体和全局数据按 JSON 字段存储，修改持久化结构（重命名字段、改变字段形状等）时：
+//  1. currentSchemaVersion 加一；
+//  2. 在 migrations 中追加一项，把上一版本的 JSON 字段改写为新版本（在实体之间移动数据时改写整个存档）。
+//
+// 加载时按版本号依次执行迁移，因此旧存档总能被还原为当前结构。迁移后的实体会在首次保存时以新版本写出。
+
+// 存档结构版本
+const (
+	schemaVersionLegacyBuildings = 1 // 城池的每个建筑独立存储（government、lumberyard 等字段）
+	schemaVersionBuildingsMap    = 2 // 城池建筑统一存入 buildings（建筑类型 -> 建筑）
+	schemaVersionEntityMarches   = 3 // 行军和战报从全局数据中拆出，分别按行军ID、玩家ID存储
+
+	currentSchemaVersion = schemaVersionEntityMarches
+)
+
+// errSchemaTooNew 存档由更新版本的服务器写出（不能降级加载，以免丢弃未知字段）
+var errSchemaTooNew = errors.New("snapshot schema is newer than supported")
+
+// jsonFields 实体的 JSON 字段
+type jsonFields map[string]json.RawMessage
+
+// migration 把存档从 from 版本升级到 from+1 版本（不需要改写的部分留空）
+type migration struct {
+	from     int
+	name     string
+	world    func(fields jsonFields) error
+	user     func(fields jsonFields) error
+	city     func(fields jsonFields) error
+	snapshot func(raw *rawSnapshot) error // 在逐个实体的迁移之后执行
+}
+
+// migrations 按版本顺序登记的迁移
+var migrations = []migration{
+	{
+		from: schemaVersionLegacyBuildings,
+		name: "city buildings map",
+		city: migrateCityBuildingsMap,
+	},
+	{
+		from:     schemaVersionBuildingsMap,
+		name:     "split marches and reports",
+		snapshot: migrateSplitWorld,
+	},
+}
+
+// migrateSnapshot 把编码后的存档从 version 版本迁移到当前版本（原地替换）
+func migrateSnapshot(version int, raw *rawSnapshot) error {
+	if version > currentSchemaVersion {
+		return fmt.Errorf("%w: version %d, supported %d", errSchemaTooNew, version, currentSchemaVersion)
+	}
+	for _, m := range migrations {
+		if m.from < version {
+			continue
+		}
+		if m.world != nil {
+			data, err := migrateEntity(raw.World, m.world)
+			if err != nil {
+				return fmt.Errorf("migration %q: world: %w", m.name, err)
+			}
+			raw.World = data
+		}
+		if err := migrateEntities(raw.Users, m.user); err != nil {
+			return fmt.Errorf("migration %q: user %w", m.name, err)
+		}
+		if err := migrateEntities(raw.Cities, m.city); err != nil {
+			return fmt.Errorf("migration %q: city %w", m.name, err)
+		}
+		if m.snapshot != nil {
+			if err := m.snapshot(raw); err != nil {
+				return fmt.Errorf("migration %q: %w", m.name, err)
+			}
+		}
+		log.Infof("Migrated snapshot schema %d -> %d (%s)", m.from, m.from+1, m.name)
+	}
+	return nil
+}
+
+// migrateEntities 对每个实体执行迁移函数
+func migrateEntities(entities map[uint]json.RawMessage, fn func(fields jsonFields) error) error {
+	if fn == nil {
+		return nil
+	}
+	for id, data := range entities {
+		migrated, err := migrateEntity(data, fn)
+		if err != nil {
+			return fmt.Errorf("%d: %w", id, err)
+		}
+		entities[id] = migrated
+	}
+	return nil
+}
+
+// migrateEntity 解析实体字段、执行迁移并重新编码
+func migrateEntity(data json.RawMessage, fn func(fields jsonFields) error) (json.RawMessage, error) {
+	var fields jsonFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if err := fn(fields); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// legacyBuildingFields 版本1中每个建筑独立存储的字段
+var legacyBuildingFields = []string{
+	"government", "lumberyard", "quarry", "iron_mine", "farm", "warehouse", "barracks",
+}
+
+// migrateCityBuildingsMap 版本1 -> 2：独立的建筑字段合并为 buildings
+// 早期未记录版本号的存档可能已经是 buildings 结构，此时只清理旧字段
+func migrateCityBuildingsMap(fields jsonFields) error {
+	_, hasMap := fields["buildings"]
+	buildings := make(map[BuildingType]json.RawMessage)
+	for _, key := range legacyBuildingFields {
+		data, ok := fields[key]
+		if !ok {
+			continue
+		}
+		delete(fields, key)
+		if hasMap || string(data) == "null" {
+			continue
+		}
+		var b BaseBuilding
+		if err := json.Unmarshal(data, &b); err != nil {
+			return fmt.Errorf("legacy building %s: %w", key, err)
+		}
+		buildings[b.Type] = data
+	}
+	if hasMap {
+		return nil
+	}
+	data, err := json.Marshal(buildings)
+	if err != nil {
+		return err
+	}
+	fields["buildings"] = data
+	return nil
+}
+
+// migrateSplitWorld 版本2 -> 3：行军和战报从全局数据移出，行军的剩余时间换算为当前阶段结束时的游戏时钟
+func migrateSplitWorld(raw *rawSnapshot) error {
+	var world jsonFields
+	if err := json.Unmarshal(raw.World, &world); err != nil {
+		return fmt.Errorf("world: %w", err)
+	}
+	var elapsed float64
+	var marches, reports map[uint]json.RawMessage
+	if err := unmarshalField(world, "elapsed", &elapsed); err != nil {
+		return err
+	}
+	if err := unmarshalField(world, "marches", &marches); err != nil {
+		return err
+	}
+	if err := unmarshalField(world, "reports", &reports); err != nil {
+		return err
+	}
+	delete(world, "marches")
+	delete(world, "reports")
+
+	for id, data := range marches {
+		if isNullJSON(data) {
+			continue
+		}
+		migrated, err := migrateEntity(data, func(fields jsonFields) error {
+			var remaining float64
+			if err := unmarshalField(fields, "remaining_time", &remaining); err != nil {
+				return err
+			}
+			endsAt, err := json.Marshal(elapsed + remaining)
+			if err != nil {
+				return err
+			}
+			fields["ends_at"] = endsAt
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("march %d: %w", id, err)
+		}
+		raw.Marches[id] = migrated
+	}
+	for userID, data := range reports {
+		raw.Reports[userID] = data
+	}
+
+	worldData, err := json.Marshal(world)
+	if err != nil {
+		return err
+	}
+	raw.World = worldData
+	return nil
+}
+
+// splitLegacyState 把版本1的整体 GameState 存档拆分为全局数据、玩家和城池（行军和战报由后续迁移拆出）
+func splitLegacyState(data []byte) (*rawSnapshot, error) {
+	var world jsonFields
+	if err := json.Unmarshal(data, &world); err != nil {
+		return nil, err
+	}
+	var byName, cities map[string]json.RawMessage
+	if err := unmarshalField(world, "users", &byName); err != nil {
+		return nil, err
+	}
+	if err := unmarshalField(world, "cities", &cities); err != nil {
+		return nil, err
+	}
+	delete(world, "users")
+	delete(world, "cities")
+
+	worldData, err := json.Marshal(world)
+	if err != nil {
+		return nil, err
+	}
+	raw := newRawSnapshot(worldData)
+	for name, u := range byName {
+		var key struct {
+			ID uint `json:"id"`
+		}
+		if err := json.Unmarshal(u, &key); err != nil {
+			return nil, fmt.Errorf("user %s: %w", name, err)
+		}
+		raw.Users[key.ID] = u
+	}
+	for key, c := range cities {
+		id, err := strconv.ParseUint(key, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("city %s: %w", key, err)
+		}
+		raw.Cities[uint(id)] = c
+	}
+	return raw, nil
+}
+
+// unmarshalField 解析可选字段（不存在或为 null 时保持 v 不变）
+func unmarshalField(fields jsonFields, key string, v any) error {
+	data, ok := fields[key]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
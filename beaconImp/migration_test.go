@@ -0,0 +1,163 @@
+package beaconImp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 版本1：整个文件就是 GameState，城池的每个建筑独立存储，行军和战报在全局数据中
+const legacyStateFixture = `{
+	"next_user_id": 2, "next_city_id": 2, "next_march_id": 2, "next_report_id": 2,
+	"last_command_seq": 7, "elapsed": 100.5,
+	"users": {"alice": {"id": 1, "username": "alice", "password": "x", "city_ids": [1]}},
+	"cities": {"1": {"id": 1, "user_id": 1, "name": "c", "pos_x": 10, "pos_y": 20, "wood": 123,
+		"government": {"type": "government", "level": 3},
+		"farm": {"type": "farm", "level": 2},
+		"barracks": null}},
+	"marches": {"1": {"id": 1, "user_id": 1, "origin_city_id": 1, "type": "attack", "status": "outbound",
+		"total_time": 60, "remaining_time": 40}},
+	"reports": {"1": [{"id": 1, "user_id": 1, "type": "battle", "title": "r", "created_at": 5}]}
+}`
+
+// 版本2：全局数据、玩家和城池分开存储，城池建筑统一存入 buildings，行军和战报仍在全局数据中
+const buildingsMapFixture = `{
+	"world": {"next_user_id": 2, "next_city_id": 2, "next_march_id": 2, "next_report_id": 2,
+		"last_command_seq": 7, "elapsed": 100.5,
+		"marches": {"1": {"id": 1, "user_id": 1, "origin_city_id": 1, "type": "attack", "status": "outbound",
+			"total_time": 60, "remaining_time": 40}},
+		"reports": {"1": [{"id": 1, "user_id": 1, "type": "battle", "title": "r", "created_at": 5}]}},
+	"users": {"1": {"id": 1, "username": "alice", "password": "x", "city_ids": [1]}},
+	"cities": {"1": {"id": 1, "user_id": 1, "name": "c", "pos_x": 10, "pos_y": 20, "wood": 123,
+		"buildings": {"government": {"type": "government", "level": 3}, "farm": {"type": "farm", "level": 2}}}}
+}`
+
+// 当前版本：行军和战报分别按行军ID、玩家ID存储
+const splitWorldFixture = `{
+	"world": {"next_user_id": 2, "next_city_id": 2, "next_march_id": 2, "next_report_id": 2,
+		"last_command_seq": 7, "elapsed": 100.5},
+	"users": {"1": {"id": 1, "username": "alice", "password": "x", "city_ids": [1]}},
+	"cities": {"1": {"id": 1, "user_id": 1, "name": "c", "pos_x": 10, "pos_y": 20, "wood": 123,
+		"buildings": {"government": {"type": "government", "level": 3}, "farm": {"type": "farm", "level": 2}}}},
+	"marches": {"1": {"id": 1, "user_id": 1, "origin_city_id": 1, "type": "attack", "status": "outbound",
+		"total_time": 60, "ends_at": 140.5}},
+	"reports": {"1": [{"id": 1, "user_id": 1, "type": "battle", "title": "r", "created_at": 5}]}
+}`
+
+// writeTestSnapshotFile 按指定存档版本写出未压缩的快照文件（version 为 0 时不写文件头）
+func writeTestSnapshotFile(t *testing.T, name string, version int, body string) {
+	t.Helper()
+	data := []byte(body)
+	if version > 0 {
+		sum := sha256.Sum256(data)
+		header := fmt.Sprintf("%s schema=%d codec=none size=%d sha256=%s\n",
+			snapshotMagic, version, len(data), hex.EncodeToString(sum[:]))
+		data = append([]byte(header), data...)
+	}
+	if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(snapshotDir, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadSnapshotMigratesToCurrentSchema(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		version int // 0 表示没有文件头的最早格式
+		body    string
+	}{
+		{"legacy state", 0, legacyStateFixture},
+		{"buildings map", schemaVersionBuildingsMap, buildingsMapFixture},
+		{"split world", schemaVersionEntityMarches, splitWorldFixture},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			writeTestSnapshotFile(t, "snapshot_2026-01-01_00-00-00.json", tc.version, tc.body)
+
+			state, err := loadSnapshot("snapshot_2026-01-01_00-00-00.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if state.Elapsed != 100.5 || state.LastCommandSeq != 7 || state.NextMarchID != 2 {
+				t.Fatalf("world = elapsed %v, seq %d, next march %d", state.Elapsed, state.LastCommandSeq, state.NextMarchID)
+			}
+			user, err := state.GetUserByUsername("alice")
+			if err != nil || user.ID != 1 {
+				t.Fatalf("user alice = %+v, %v", user, err)
+			}
+
+			city := state.Cities[1]
+			if city == nil || city.Wood != 123 || city.PosX != 10 || city.PosY != 20 {
+				t.Fatalf("city 1 = %+v", city)
+			}
+			want := map[BuildingType]int{"government": 3, "farm": 2}
+			if len(city.Buildings) != len(want) {
+				t.Fatalf("buildings = %v, want %v", city.Buildings, want)
+			}
+			for buildingType, level := range want {
+				if b := city.Buildings[buildingType]; b == nil || b.Type != buildingType || b.Level != level {
+					t.Fatalf("building %s = %+v, want level %d", buildingType, b, level)
+				}
+			}
+
+			m := state.Marches[1]
+			if m == nil || m.EndsAt != 140.5 || m.RemainingTime(state.Elapsed) != 40 {
+				t.Fatalf("march 1 = %+v", m)
+			}
+			if reports := state.Reports[1]; len(reports) != 1 || reports[0].Title != "r" {
+				t.Fatalf("reports of user 1 = %v", reports)
+			}
+		})
+	}
+}
+
+func TestMigrateSnapshotRewritesFields(t *testing.T) {
+	raw, err := splitLegacyState([]byte(legacyStateFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateSnapshot(schemaVersionLegacyBuildings, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	var world, city, march jsonFields
+	for _, v := range []struct {
+		data   json.RawMessage
+		fields *jsonFields
+	}{{raw.World, &world}, {raw.Cities[1], &city}, {raw.Marches[1], &march}} {
+		if err := json.Unmarshal(v.data, v.fields); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"users", "cities", "marches", "reports"} {
+		if _, ok := world[key]; ok {
+			t.Errorf("world still contains %q", key)
+		}
+	}
+	for _, key := range legacyBuildingFields {
+		if _, ok := city[key]; ok {
+			t.Errorf("city still contains legacy building field %q", key)
+		}
+	}
+	if string(march["ends_at"]) != "140.5" {
+		t.Errorf("march ends_at = %s, want 140.5", march["ends_at"])
+	}
+	if len(raw.Users) != 1 || len(raw.Reports) != 1 {
+		t.Errorf("users = %d, reports = %d, want 1 and 1", len(raw.Users), len(raw.Reports))
+	}
+}
+
+func TestMigrateSnapshotRejectsNewerSchema(t *testing.T) {
+	raw := newRawSnapshot(json.RawMessage(`{}`))
+	if err := migrateSnapshot(currentSchemaVersion+1, raw); !errors.Is(err, errSchemaTooNew) {
+		t.Fatalf("err = %v, want errSchemaTooNew", err)
+	}
+}
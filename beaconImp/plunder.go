@@ -0,0 +1,88 @@
+package beaconImp
+
+import "beacon/config"
+
+// ========== Plunder - 掠夺 ==========
+
+// CalcCarryCapacity 计算部队总负重
+func CalcCarryCapacity(troops []*Troop) int {
+	capacity := 0
+	for _, t := range troops {
+		if troopConf := config.GetTroopConfig(string(t.Type)); troopConf != nil {
+			capacity += t.Quantity * troopConf.Capacity
+		}
+	}
+	return capacity
+}
+
+// PlunderCity 从城池掠夺资源（木/石/铁/粮），并从城池中扣除
+// 负重在各类资源之间平均分配，某类资源不足时，剩余负重分给其他资源
+func PlunderCity(city *City, capacity int) Resources {
+	available := [4]int{city.Wood, city.Stone, city.Iron, city.Food}
+	taken := splitLoot(available, capacity)
+
+	city.Wood -= taken[0]
+	city.Stone -= taken[1]
+	city.Iron -= taken[2]
+	city.Food -= taken[3]
+
+	return Resources{
+		Wood:  taken[0],
+		Stone: taken[1],
+		Iron:  taken[2],
+		Food:  taken[3],
+	}
+}
+
+// splitLoot 按负重平均分配各类资源的掠夺量
+func splitLoot(available [4]int, capacity int) [4]int {
+	var taken [4]int
+	remaining := capacity
+
+	for remaining > 0 {
+		// 仍有剩余库存的资源类型
+		open := make([]int, 0, len(available))
+		for i := range available {
+			if available[i]-taken[i] > 0 {
+				open = append(open, i)
+			}
+		}
+		if len(open) == 0 {
+			break
+		}
+
+		share := remaining / len(open)
+		if share == 0 {
+			// 负重不足以平分，逐个分配剩余的零头
+			for _, i := range open {
+				if remaining == 0 {
+					break
+				}
+				taken[i]++
+				remaining--
+			}
+			continue
+		}
+
+		for _, i := range open {
+			take := min(share, available[i]-taken[i])
+			taken[i] += take
+			remaining -= take
+		}
+	}
+	return taken
+}
+
+// AddResources 将资源存入城池（调用者负责随后应用仓库上限）
+func (c *City) AddResources(r Resources) {
+	c.Wood += r.Wood
+	c.Stone += r.Stone
+	c.Iron += r.Iron
+	c.Food += r.Food
+	c.Gold += r.Gold
+}
+
+// IsEmpty 资源是否为空
+func (r Resources) IsEmpty() bool {
+	return r.Wood == 0 && r.Stone == 0 && r.Iron == 0 && r.Food == 0 && r.Gold == 0
+}
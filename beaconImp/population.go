@@ -0,0 +1,110 @@
+package beaconImp
+
+import "beacon/config"
+
+// ========== Population - 人口 ==========
+//
+// 人口上限由建筑提供（BuildingLevelConf.PopulationCapacity，目前为农田）。
+// 人口占用：
+//  1. 建筑：每个建筑占用其当前等级的 PopulationCost（不累加低等级）；
+//     已排队的升级按目标等级预占
+//  2. 部队：每个士兵占用兵种的 PopulationCost，包括城内驻军、从本城出发的行军部队、
+//     驻防在其他城池的部队和招募队列中尚未完成的士兵
+
+// buildingPopulation 建筑在指定等级占用的人口
+func buildingPopulation(buildingType BuildingType, level int) int {
+	conf := config.GetBuildingLevel(string(buildingType), level)
+	if conf == nil {
+		return 0
+	}
+	return conf.PopulationCost
+}
+
+// troopsPopulation 部队占用的人口
+func troopsPopulation(troops []*Troop) int {
+	population := 0
+	for _, t := range troops {
+		if troopConf := config.GetTroopConfig(string(t.Type)); troopConf != nil {
+			population += t.Quantity * troopConf.PopulationCost
+		}
+	}
+	return population
+}
+
+// plannedBuildingLevel 建筑计入升级队列后的等级（新的升级任务以此为基础，也用于人口预占）
+func (c *City) plannedBuildingLevel(building *BaseBuilding) int {
+	level := building.Level
+	for _, q := range c.BuildingUpgradeQueue {
+		if q.BuildingType == building.Type && q.TargetLevel > level {
+			level = q.TargetLevel
+		}
+	}
+	return level
+}
+
+// CalcPopulationCapacity 计算城池人口上限
+func (c *City) CalcPopulationCapacity() int {
+	capacity := 0
+	for _, b := range c.GetAllBuildings() {
+		if b == nil {
+			continue
+		}
+		if conf := config.GetBuildingLevel(string(b.Type), b.Level); conf != nil {
+			capacity += conf.PopulationCapacity
+		}
+	}
+	return capacity
+}
+
+// calcPopulationCapacityAfterUpgrade 计算建筑升到指定等级后的人口上限
+// 用于升级校验：提供人口的建筑（农田）升级时按升级后的上限判断，避免人口满时无法扩容
+func (c *City) calcPopulationCapacityAfterUpgrade(building *BaseBuilding, targetLevel int) int {
+	capacity := c.CalcPopulationCapacity()
+	currentConf := config.GetBuildingLevel(string(building.Type), building.Level)
+	targetConf := config.GetBuildingLevel(string(building.Type), targetLevel)
+	if currentConf != nil && targetConf != nil {
+		capacity += targetConf.PopulationCapacity - currentConf.PopulationCapacity
+	}
+	return capacity
+}
+
+// CalcCityPopulationUsed 计算城池已占用人口
+func (B *Beacon) CalcCityPopulationUsed(city *City) int {
+	used := 0
+	for _, b := range city.GetAllBuildings() {
+		if b == nil {
+			continue
+		}
+		used += buildingPopulation(b.Type, city.plannedBuildingLevel(b))
+	}
+
+	used += troopsPopulation(city.Troops)
+	for _, m := range B.state.Marches {
+		if m.OriginCityID == city.ID {
+			used += troopsPopulation(m.Troops)
+		}
+	}
+	for _, g := range B.state.ListGarrisonsAbroad(city.ID) {
+		used += troopsPopulation(g.Troops)
+	}
+	for _, q := range city.RecruitQueue {
+		if troopConf := config.GetTroopConfig(string(q.TroopType)); troopConf != nil {
+			used += q.RemainingQty * troopConf.PopulationCost
+		}
+	}
+	return used
+}
+
+// CheckBuildingUpgradePopulation 检查建筑升级到目标等级后人口是否超出上限
+func (B *Beacon) CheckBuildingUpgradePopulation(city *City, building *BaseBuilding, targetLevel int) bool {
+	used := B.CalcCityPopulationUsed(city)
+	used += buildingPopulation(building.Type, targetLevel) -
+		buildingPopulation(building.Type, city.plannedBuildingLevel(building))
+	return used <= city.calcPopulationCapacityAfterUpgrade(building, targetLevel)
+}
+
+// CheckRecruitPopulation 检查招募后人口是否超出上限
+func (B *Beacon) CheckRecruitPopulation(city *City, troopConf *config.TroopAttr, quantity int) bool {
+	used := B.CalcCityPopulationUsed(city) + quantity*troopConf.PopulationCost
+	return used <= city.CalcPopulationCapacity()
+}
@@ -0,0 +1,262 @@
+package beaconImp
+
+import (
+	"errors"
+
+	"beacon/config"
+	"beacon/log"
+)
+
+// ========== Queue Management - 队列取消与调整 ==========
+//
+// 队列任务按下标定位，同时校验任务类型，避免队首任务完成导致下标错位时误操作。
+// 取消任务按 economy.toml 中的 cancel_refund_percent 返还已支付资源（超出仓库容量的部分作废）：
+//  1. 建筑升级：返还该任务的全部花费，正在进行的任务进度作废
+//  2. 招募：只返还尚未完成的士兵，已完成的士兵保留在城池中
+// 调整顺序时队列中的任务保留各自的剩余时间，被移出队首的任务暂停，回到队首后继续。
+
+// cancelRefundPercent 取消队列任务的资源返还百分比
+func cancelRefundPercent() int {
+	if config.EconomyConfig == nil {
+		return 0
+	}
+	return max(0, min(config.EconomyConfig.Queue.CancelRefundPercent, 100))
+}
+
+// scaleResources 按百分比缩放资源（向下取整）
+func scaleResources(r Resources, percent int) Resources {
+	scale := func(v int) int {
+		return v * percent / 100
+	}
+	return Resources{
+		Wood:  scale(r.Wood),
+		Stone: scale(r.Stone),
+		Iron:  scale(r.Iron),
+		Food:  scale(r.Food),
+		Gold:  scale(r.Gold),
+	}
+}
+
+// multiplyResources 资源乘以数量
+func multiplyResources(r Resources, n int) Resources {
+	return Resources{
+		Wood:  r.Wood * n,
+		Stone: r.Stone * n,
+		Iron:  r.Iron * n,
+		Food:  r.Food * n,
+		Gold:  r.Gold * n,
+	}
+}
+
+// moveQueueItem 将队列中 from 位置的任务移动到 to 位置
+func moveQueueItem[T any](queue []T, from, to int) {
+	item := queue[from]
+	if from < to {
+		copy(queue[from:to], queue[from+1:to+1])
+	} else {
+		copy(queue[to+1:from+1], queue[to:from])
+	}
+	queue[to] = item
+}
+
+// buildingUpgradeCost 建筑升级任务已支付的资源（兼容未记录花费的旧快照，按配置计算）
+func (q *BuildingUpgradeQueue) buildingUpgradeCost() Resources {
+	if !q.Cost.IsEmpty() {
+		return q.Cost
+	}
+	levelConf := config.GetBuildingLevel(string(q.BuildingType), q.TargetLevel)
+	if levelConf == nil {
+		return Resources{}
+	}
+	return buildingLevelCost(levelConf)
+}
+
+// buildingLevelCost 建筑升到指定等级的花费
+func buildingLevelCost(levelConf *config.BuildingLevelConf) Resources {
+	return Resources{
+		Wood:  levelConf.UpgradeCostWood,
+		Stone: levelConf.UpgradeCostStone,
+		Iron:  levelConf.UpgradeCostIron,
+		Food:  levelConf.UpgradeCostFood,
+		Gold:  levelConf.UpgradeCostGold,
+	}
+}
+
+// recruitUnitCost 招募任务单个士兵已支付的资源（兼容未记录花费的旧快照，按配置计算）
+func (q *RecruitQueue) recruitUnitCost() Resources {
+	if !q.CostPerUnit.IsEmpty() {
+		return q.CostPerUnit
+	}
+	troopConf := config.GetTroopConfig(string(q.TroopType))
+	if troopConf == nil {
+		return Resources{}
+	}
+	return troopUnitCost(troopConf)
+}
+
+// troopUnitCost 单个士兵的招募花费
+func troopUnitCost(troopConf *config.TroopAttr) Resources {
+	return Resources{
+		Wood:  troopConf.RecruitCostWood,
+		Stone: troopConf.RecruitCostStone,
+		Iron:  troopConf.RecruitCostIron,
+		Food:  troopConf.RecruitCostFood,
+	}
+}
+
+// findBuildingUpgrade 按下标定位建筑升级任务并校验建筑类型
+func (c *City) findBuildingUpgrade(index int, buildingType BuildingType) (*BuildingUpgradeQueue, error) {
+	if index < 0 || index >= len(c.BuildingUpgradeQueue) {
+		return nil, errors.New("队列任务不存在")
+	}
+	q := c.BuildingUpgradeQueue[index]
+	if q.BuildingType != buildingType {
+		return nil, errors.New("队列已变化，请刷新后重试")
+	}
+	return q, nil
+}
+
+// findRecruit 按下标定位招募任务并校验兵种
+func (c *City) findRecruit(index int, troopType TroopType) (*RecruitQueue, error) {
+	if index < 0 || index >= len(c.RecruitQueue) {
+		return nil, errors.New("队列任务不存在")
+	}
+	q := c.RecruitQueue[index]
+	if q.TroopType != troopType {
+		return nil, errors.New("队列已变化，请刷新后重试")
+	}
+	return q, nil
+}
+
+// CancelBuildingUpgrade 取消建筑升级任务并返还资源
+// 同一建筑更高等级的任务依赖该任务，需先取消
+// 注意：调用者需持有写锁
+func (B *Beacon) CancelBuildingUpgrade(city *City, index int, buildingType BuildingType) (Resources, error) {
+	q, err := city.findBuildingUpgrade(index, buildingType)
+	if err != nil {
+		return Resources{}, err
+	}
+	for _, other := range city.BuildingUpgradeQueue[index+1:] {
+		if other.BuildingType == q.BuildingType && other.TargetLevel > q.TargetLevel {
+			return Resources{}, errors.New("请先取消该建筑更高等级的升级")
+		}
+	}
+
+	city.BuildingUpgradeQueue = append(city.BuildingUpgradeQueue[:index], city.BuildingUpgradeQueue[index+1:]...)
+
+	refund := scaleResources(q.buildingUpgradeCost(), cancelRefundPercent())
+	city.AddResources(refund)
+	B.applyCityResourceCap(city)
+
+	log.Infof("Building upgrade canceled: city=%d, building=%s, level=%d, refund=%+v",
+		city.ID, q.BuildingNameCN, q.TargetLevel, refund)
+	return refund, nil
+}
+
+// CancelRecruit 取消招募任务，按未完成的士兵数量返还资源
+// 注意：调用者需持有写锁
+func (B *Beacon) CancelRecruit(city *City, index int, troopType TroopType) (Resources, error) {
+	q, err := city.findRecruit(index, troopType)
+	if err != nil {
+		return Resources{}, err
+	}
+
+	city.RecruitQueue = append(city.RecruitQueue[:index], city.RecruitQueue[index+1:]...)
+
+	refund := scaleResources(multiplyResources(q.recruitUnitCost(), q.RemainingQty), cancelRefundPercent())
+	city.AddResources(refund)
+	B.applyCityResourceCap(city)
+
+	log.Infof("Recruit canceled: city=%d, type=%s, untrained=%d/%d, refund=%+v",
+		city.ID, q.TroopNameCN, q.RemainingQty, q.TotalQuantity, refund)
+	return refund, nil
+}
+
+// ReorderBuildingUpgrade 调整建筑升级任务的顺序
+// 同一建筑的任务必须保持等级从低到高的顺序
+// 注意：调用者需持有写锁
+func (B *Beacon) ReorderBuildingUpgrade(city *City, from int, buildingType BuildingType, to int) error {
+	q, err := city.findBuildingUpgrade(from, buildingType)
+	if err != nil {
+		return err
+	}
+	if to < 0 || to >= len(city.BuildingUpgradeQueue) {
+		return errors.New("目标位置无效")
+	}
+
+	// 移动后，任务不能越过同一建筑的其他任务
+	lo, hi := min(from, to), max(from, to)
+	for i := lo; i <= hi; i++ {
+		if i != from && city.BuildingUpgradeQueue[i].BuildingType == q.BuildingType {
+			return errors.New("同一建筑的升级必须按等级顺序进行")
+		}
+	}
+
+	moveQueueItem(city.BuildingUpgradeQueue, from, to)
+	log.Infof("Building upgrade reordered: city=%d, building=%s, level=%d, %d->%d",
+		city.ID, q.BuildingNameCN, q.TargetLevel, from, to)
+	return nil
+}
+
+// ReorderRecruit 调整招募任务的顺序
+// 注意：调用者需持有写锁
+func (B *Beacon) ReorderRecruit(city *City, from int, troopType TroopType, to int) error {
+	q, err := city.findRecruit(from, troopType)
+	if err != nil {
+		return err
+	}
+	if to < 0 || to >= len(city.RecruitQueue) {
+		return errors.New("目标位置无效")
+	}
+
+	moveQueueItem(city.RecruitQueue, from, to)
+	log.Infof("Recruit reordered: city=%d, type=%s, %d->%d", city.ID, q.TroopNameCN, from, to)
+	return nil
+}
+
+// fixBuildingQueueTargets 修正旧快照中同一建筑重复排队时的目标等级
+// 旧版本每次排队都以当前等级+1为目标，同一建筑排队多次会得到相同的目标等级；
+// 加载时按队列顺序依次顺延，顺延后的任务按新等级重新计算耗时和花费（队首任务保留已进行的时间）；
+// 超出最高等级的任务直接移除并返还已支付的资源（超出仓库容量的部分作废）。
+func (B *Beacon) fixBuildingQueueTargets(c *City) {
+	planned := make(map[BuildingType]int)
+	fixed := c.BuildingUpgradeQueue[:0]
+	refunded := false
+	for i, q := range c.BuildingUpgradeQueue {
+		level, ok := planned[q.BuildingType]
+		if !ok {
+			if b := c.GetBuildingByType(q.BuildingType); b != nil {
+				level = b.Level
+			}
+		}
+		paid := q.buildingUpgradeCost()
+		// 只有队首任务在进行中，已进行的时间按原目标等级的耗时计算
+		elapsed := 0.0
+		if oldConf := config.GetBuildingLevel(string(q.BuildingType), q.TargetLevel); i == 0 && oldConf != nil {
+			elapsed = max(c.CalcBuildingUpgradeTime(oldConf)-q.RemainingTime, 0)
+		}
+		raised := q.TargetLevel <= level
+		if raised {
+			q.TargetLevel = level + 1
+		}
+
+		levelConf := config.GetBuildingLevel(string(q.BuildingType), q.TargetLevel)
+		if levelConf == nil {
+			c.AddResources(paid)
+			refunded = true
+			log.Warnf("Dropped invalid building upgrade: city=%d, building=%s, level=%d, refund=%+v",
+				c.ID, q.BuildingType, q.TargetLevel, paid)
+			continue
+		}
+		if raised {
+			q.RemainingTime = max(c.CalcBuildingUpgradeTime(levelConf)-elapsed, 0)
+			q.Cost = buildingLevelCost(levelConf)
+		}
+		planned[q.BuildingType] = q.TargetLevel
+		fixed = append(fixed, q)
+	}
+	c.BuildingUpgradeQueue = fixed
+	if refunded {
+		B.applyCityResourceCap(c)
+	}
+}
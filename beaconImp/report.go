@@ -0,0 +1,178 @@
+package beaconImp
+
+import (
+	"errors"
+	"time"
+)
+
+// ========== Report - 战报 ==========
+
+const (
+	maxReportsPerUser = 100                // 每个玩家最多保留的战报数
+	reportRetention   = 7 * 24 * time.Hour // 战报保留时长
+)
+
+type ReportType string
+
+const (
+	ReportBattle    ReportType = "battle"    // 战斗
+	ReportSettle    ReportType = "settle"    // 拓荒
+	ReportTransport ReportType = "transport" // 运输
+	ReportScout     ReportType = "scout"     // 侦察
+)
+
+// Resources 资源数量（掠夺、运输等）
+type Resources struct {
+	Wood  int `json:"wood"`
+	Stone int `json:"stone"`
+	Iron  int `json:"iron"`
+	Food  int `json:"food"`
+	Gold  int `json:"gold"`
+}
+
+// ReportSide 战报中一方的信息
+type ReportSide struct {
+	UserID   uint              `json:"user_id"`
+	Username string            `json:"username"`
+	CityID   uint              `json:"city_id"`
+	CityName string            `json:"city_name"`
+	PosX     int               `json:"pos_x"`
+	PosY     int               `json:"pos_y"`
+	Troops   []*Troop          `json:"troops"` // 战前部队
+	Losses   map[TroopType]int `json:"losses"` // 各兵种损失
+}
+
+// Report 战报（每个玩家各持有一份副本）
+type Report struct {
+	ID          uint        `json:"id"`
+	UserID      uint        `json:"user_id"` // 战报所属玩家
+	Type        ReportType  `json:"type"`
+	Title       string      `json:"title"`
+	CreatedAt   int64       `json:"created_at"` // Unix 时间戳（秒）
+	Read        bool        `json:"read"`
+	Attacker    *ReportSide `json:"attacker,omitempty"`
+	Defender    *ReportSide `json:"defender,omitempty"`
+	AttackerWon bool        `json:"attacker_won"`
+	Loot        Resources   `json:"loot"`
+	Message     string      `json:"message,omitempty"` // 非战斗类战报的说明
+	Intel       *ScoutIntel `json:"intel,omitempty"`   // 侦察情报
+}
+
+// ScoutIntel 侦察得到的城池情报
+type ScoutIntel struct {
+	Resources Resources            `json:"resources"`
+	Troops    []*Troop             `json:"troops"`
+	Buildings map[BuildingType]int `json:"buildings"` // 建筑类型 -> 等级
+}
+
+// ========== Report Methods ==========
+
+// AddReport 为玩家添加战报（超出数量上限时丢弃最旧的）
+func (gs *GameState) AddReport(r *Report) {
+	r.ID = gs.NextReportID
+	gs.NextReportID++
+
+	reports := append(gs.Reports[r.UserID], r)
+	if len(reports) > maxReportsPerUser {
+		reports = reports[len(reports)-maxReportsPerUser:]
+	}
+	gs.Reports[r.UserID] = reports
+	gs.MarkReportsDirty(r.UserID)
+}
+
+// ListReports 获取玩家所有战报（按时间从旧到新）
+func (gs *GameState) ListReports(userID uint) []*Report {
+	return gs.Reports[userID]
+}
+
+// GetReport 获取玩家的指定战报
+func (gs *GameState) GetReport(userID, reportID uint) (*Report, error) {
+	for _, r := range gs.Reports[userID] {
+		if r.ID == reportID {
+			return r, nil
+		}
+	}
+	return nil, errors.New("report not found")
+}
+
+// DeleteReport 删除玩家的指定战报
+func (gs *GameState) DeleteReport(userID, reportID uint) error {
+	reports := gs.Reports[userID]
+	for i, r := range reports {
+		if r.ID == reportID {
+			gs.Reports[userID] = append(reports[:i], reports[i+1:]...)
+			gs.MarkReportsDirty(userID)
+			return nil
+		}
+	}
+	return errors.New("report not found")
+}
+
+// PruneReports 清理过期战报
+func (gs *GameState) PruneReports(now time.Time) {
+	deadline := now.Add(-reportRetention).Unix()
+	for userID, reports := range gs.Reports {
+		// 战报按时间顺序追加，只需找到第一个未过期的位置
+		i := 0
+		for i < len(reports) && reports[i].CreatedAt < deadline {
+			i++
+		}
+		if i == 0 {
+			continue
+		}
+		gs.MarkReportsDirty(userID)
+		if i == len(reports) {
+			delete(gs.Reports, userID)
+		} else {
+			gs.Reports[userID] = reports[i:]
+		}
+	}
+}
+
+// newReportSide 根据城池生成战报一方的信息
+func (gs *GameState) newReportSide(userID uint, city *City, troops []*Troop, losses map[TroopType]int) *ReportSide {
+	side := &ReportSide{
+		UserID: userID,
+		Troops: copyTroops(troops),
+		Losses: losses,
+	}
+	if user, err := gs.GetUserByID(userID); err == nil {
+		side.Username = user.Username
+	}
+	if city != nil {
+		side.CityID = city.ID
+		side.CityName = city.Name
+		side.PosX = city.PosX
+		side.PosY = city.PosY
+	}
+	return side
+}
+
+// addBattleReports 为攻防双方生成战报
+func (B *Beacon) addBattleReports(m *March, target *City, attackerTroops, defenderTroops []*Troop, result *BattleResult, loot Resources) {
+	origin, _ := B.state.GetCity(m.OriginCityID)
+	now := time.Now().Unix()
+
+	for _, owner := range []uint{m.UserID, target.UserID} {
+		title := "进攻 " + target.Name
+		if owner == target.UserID {
+			title = "防守 " + target.Name
+		}
+		if result.AttackerWon {
+			title += "（进攻方胜利）"
+		} else {
+			title += "（防守方胜利）"
+		}
+
+		B.state.AddReport(&Report{
+			UserID:      owner,
+			Type:        ReportBattle,
+			Title:       title,
+			CreatedAt:   now,
+			Attacker:    B.state.newReportSide(m.UserID, origin, attackerTroops, result.AttackerLosses),
+			Defender:    B.state.newReportSide(target.UserID, target, defenderTroops, result.DefenderLosses),
+			AttackerWon: result.AttackerWon,
+			Loot:        loot,
+		})
+	}
+}
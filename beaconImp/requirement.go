@@ -0,0 +1,36 @@
+package beaconImp
+
+import (
+	"fmt"
+	"strings"
+
+	"beacon/config"
+)
+
+// ========== Requirement - 前置条件（科技树） ==========
+//
+// 建筑等级和兵种可在配置中声明 requires（建筑类型 -> 最低等级），
+// 只有城池内对应建筑的当前等级（不含升级队列中尚未完成的等级）全部达标才能升级/招募。
+
+// CheckRequirements 检查城池是否满足前置条件，不满足时返回未满足条件的说明
+func (c *City) CheckRequirements(requires map[string]int) (bool, string) {
+	var unmet []string
+	// 按建筑显示顺序检查，保证说明文字顺序稳定
+	for _, buildingType := range config.ListBuildingTypes() {
+		level, ok := requires[buildingType]
+		if !ok {
+			continue
+		}
+		current := 0
+		if b := c.GetBuildingByType(BuildingType(buildingType)); b != nil {
+			current = b.Level
+		}
+		if current < level {
+			unmet = append(unmet, fmt.Sprintf("%s%d级", GetBuildingNameCN(BuildingType(buildingType)), level))
+		}
+	}
+	if len(unmet) > 0 {
+		return false, "需要" + strings.Join(unmet, "、")
+	}
+	return true, ""
+}
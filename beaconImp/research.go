@@ -0,0 +1,186 @@
+package beaconImp
+
+import (
+	"errors"
+	"math"
+
+	"beacon/config"
+	"beacon/log"
+)
+
+// ========== Research - 科技研究 ==========
+//
+// 科技等级属于玩家，对玩家所有城池和部队生效：
+//  1. production：木/石/铁/粮产量加成
+//  2. attack：部队近战和远程攻击力加成（进攻和防守均生效）
+//  3. march_speed：行军速度加成（按出征时的科技等级计算，之后升级不影响已出发的行军）
+//  4. carry_capacity：部队负重加成（掠夺和运输）
+// 研究在指定城池发起：检查该城池的前置建筑并扣除资源，任务加入玩家的研究队列，
+// 与建筑队列一样允许排队，但同一时间只执行第一个。
+
+// applyBonusPercent 按加成百分比放大数值
+func applyBonusPercent(value float64, percent int) float64 {
+	return value * float64(100+percent) / 100.0
+}
+
+// plannedResearchLevel 科技计入研究队列后的等级
+func (u *User) plannedResearchLevel(researchType ResearchType) int {
+	level := u.Research[researchType]
+	for _, q := range u.ResearchQueue {
+		if q.ResearchType == researchType && q.TargetLevel > level {
+			level = q.TargetLevel
+		}
+	}
+	return level
+}
+
+// GetResearchBonus 获取玩家指定效果的科技加成百分比（各科技之和）
+func (gs *GameState) GetResearchBonus(userID uint, effect string) int {
+	user, err := gs.GetUserByID(userID)
+	if err != nil {
+		return 0
+	}
+	bonus := 0
+	for researchType, level := range user.Research {
+		rConf := config.GetResearchTypeConf(string(researchType))
+		if rConf == nil || rConf.Effect != effect {
+			continue
+		}
+		if levelConf := config.GetResearchLevel(string(researchType), level); levelConf != nil {
+			bonus += levelConf.BonusPercent
+		}
+	}
+	return bonus
+}
+
+// GetResearchNameCN 获取科技中文名
+func GetResearchNameCN(t ResearchType) string {
+	if rConf := config.GetResearchTypeConf(string(t)); rConf != nil && rConf.Name != "" {
+		return rConf.Name
+	}
+	return string(t)
+}
+
+// StartResearch 在城池发起研究，研究下一级（计入队列中已排队的等级）
+// 注意：调用者需持有写锁
+func (B *Beacon) StartResearch(city *City, researchType ResearchType) (*ResearchQueue, error) {
+	user, err := B.state.GetUserByID(city.UserID)
+	if err != nil {
+		return nil, errors.New("玩家不存在")
+	}
+	if config.GetResearchTypeConf(string(researchType)) == nil {
+		return nil, errors.New("科技不存在")
+	}
+
+	targetLevel := user.plannedResearchLevel(researchType) + 1
+	levelConf := config.GetResearchLevel(string(researchType), targetLevel)
+	if levelConf == nil {
+		return nil, errors.New("已达最高等级")
+	}
+	if ok, reason := city.CheckRequirements(levelConf.Requires); !ok {
+		return nil, errors.New(reason)
+	}
+	if city.Wood < levelConf.CostWood || city.Stone < levelConf.CostStone ||
+		city.Iron < levelConf.CostIron || city.Food < levelConf.CostFood ||
+		city.Gold < levelConf.CostGold {
+		return nil, errors.New("资源不足")
+	}
+
+	city.Wood -= levelConf.CostWood
+	city.Stone -= levelConf.CostStone
+	city.Iron -= levelConf.CostIron
+	city.Food -= levelConf.CostFood
+	city.Gold -= levelConf.CostGold
+
+	queue := &ResearchQueue{
+		ResearchType:   researchType,
+		ResearchNameCN: GetResearchNameCN(researchType),
+		TargetLevel:    targetLevel,
+		CityID:         city.ID,
+		RemainingTime:  float64(levelConf.ResearchTimeSeconds),
+	}
+	user.ResearchQueue = append(user.ResearchQueue, queue)
+	B.state.MarkUserDirty(user.ID)
+
+	log.Infof("Research queued: user=%d, city=%d, research=%s, level=%d, time=%.0fs",
+		user.ID, city.ID, queue.ResearchNameCN, queue.TargetLevel, queue.RemainingTime)
+	return queue, nil
+}
+
+// processResearch 推进所有玩家的研究队列（只处理每个玩家的第一个任务）
+func (B *Beacon) processResearch(deltaSeconds float64) {
+	for _, user := range B.state.Users {
+		if len(user.ResearchQueue) == 0 {
+			continue
+		}
+
+		queue := user.ResearchQueue[0]
+		queue.RemainingTime -= deltaSeconds
+		B.state.MarkUserDirty(user.ID)
+		if queue.RemainingTime > 0 {
+			continue
+		}
+
+		// 研究完成
+		if user.Research == nil {
+			user.Research = make(map[ResearchType]int)
+		}
+		user.Research[queue.ResearchType] = queue.TargetLevel
+		user.ResearchQueue = user.ResearchQueue[1:]
+		log.Infof("Research completed: user=%d, research=%s, level=%d",
+			user.ID, queue.ResearchNameCN, queue.TargetLevel)
+	}
+}
+
+// ========== Research Modifiers - 科技加成 ==========
+
+// CalcCityProduction 计算城池每小时资源产量（计入玩家的产量科技加成）
+func (B *Beacon) CalcCityProduction(city *City) Resources {
+	production := city.CalcProductionPerHour()
+	bonus := B.state.GetResearchBonus(city.UserID, config.ResearchEffectProduction)
+	if bonus == 0 {
+		return production
+	}
+	boost := func(v int) int {
+		return int(math.Round(applyBonusPercent(float64(v), bonus)))
+	}
+	production.Wood = boost(production.Wood)
+	production.Stone = boost(production.Stone)
+	production.Iron = boost(production.Iron)
+	production.Food = boost(production.Food)
+	return production
+}
+
+// researchTroopStats 获取计入玩家攻击科技加成的兵种属性查询函数
+func (B *Beacon) researchTroopStats(userID uint) TroopStatsLookup {
+	bonus := B.state.GetResearchBonus(userID, config.ResearchEffectAttack)
+	if bonus == 0 {
+		return ConfigTroopStats
+	}
+	return func(troopType TroopType) *config.TroopAttr {
+		attr := ConfigTroopStats(troopType)
+		if attr == nil {
+			return nil
+		}
+		boosted := *attr
+		boosted.MeleeAttack = int(math.Round(applyBonusPercent(float64(attr.MeleeAttack), bonus)))
+		boosted.RangedAttack = int(math.Round(applyBonusPercent(float64(attr.RangedAttack), bonus)))
+		return &boosted
+	}
+}
+
+// calcUserMarchSpeed 计算玩家部队的行军速度（计入行军速度科技加成）
+func (B *Beacon) calcUserMarchSpeed(userID uint, troops []*Troop) (float64, error) {
+	speed, err := CalcMarchSpeed(troops)
+	if err != nil {
+		return 0, err
+	}
+	bonus := B.state.GetResearchBonus(userID, config.ResearchEffectMarchSpeed)
+	return applyBonusPercent(float64(speed), bonus), nil
+}
+
+// calcUserCarryCapacity 计算玩家部队的总负重（计入负重科技加成）
+func (B *Beacon) calcUserCarryCapacity(userID uint, troops []*Troop) int {
+	bonus := B.state.GetResearchBonus(userID, config.ResearchEffectCarryCapacity)
+	return int(applyBonusPercent(float64(CalcCarryCapacity(troops)), bonus))
+}
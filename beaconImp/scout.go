@@ -0,0 +1,130 @@
+package beaconImp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"beacon/config"
+	"beacon/log"
+)
+
+// ========== Scout - 侦察 ==========
+//
+// 侦察行军只能由侦察职能（role = "scout"）的兵种组成。到达目标城池后与守方侦察兵比较数量：
+//  1. 守方没有侦察兵：侦察成功，无损失
+//  2. 攻方数量多于守方：侦察成功，攻方损失 攻方数量 × (守方/攻方)^1.5（各兵种按比例分摊）
+//  3. 否则侦察失败：攻方侦察兵全部阵亡，守方收到侦察警报
+// 守方侦察兵包括驻防部队中的侦察兵。
+// 侦察成功时守方不会察觉，攻方获得目标的资源、部队（含驻防）和建筑等级情报。
+
+// validateScout 派出侦察行军前的校验
+func (B *Beacon) validateScout(city *City, toX, toY int, troops []*Troop) error {
+	for _, t := range troops {
+		if !HasTroopRole(t.Type, config.TroopRoleScout) {
+			return errors.New("侦察只能派出侦察兵")
+		}
+	}
+	target, err := B.state.GetCity(B.state.WorldMap().CityAt(toX, toY))
+	if err != nil {
+		return errors.New("目标坐标没有城池")
+	}
+	if target.UserID == city.UserID {
+		return errors.New("不能侦察己方城池")
+	}
+	return nil
+}
+
+// resolveScout 侦察行军到达：与守方侦察兵对抗并收集情报
+func (B *Beacon) resolveScout(m *March) {
+	target, err := B.state.GetCity(B.state.WorldMap().CityAt(m.ToX, m.ToY))
+	if err != nil || target.UserID == m.UserID {
+		B.returnMarch(m)
+		return
+	}
+
+	scouts := totalQuantity(m.Troops)
+	defenderScouts := 0
+	for _, t := range target.DefendingTroops() {
+		if HasTroopRole(t.Type, config.TroopRoleScout) {
+			defenderScouts += t.Quantity
+		}
+	}
+
+	now := time.Now().Unix()
+	if defenderScouts >= scouts {
+		// 侦察失败：侦察兵全部阵亡，通知守方
+		losses := make(map[TroopType]int)
+		for _, t := range m.Troops {
+			losses[t.Type] += t.Quantity
+		}
+		log.Infof("Scout failed: march=%d, target_city=%d, scouts=%d, defender_scouts=%d",
+			m.ID, target.ID, scouts, defenderScouts)
+		B.state.AddReport(&Report{
+			UserID:    m.UserID,
+			Type:      ReportScout,
+			Title:     "侦察 " + target.Name + "（失败）",
+			CreatedAt: now,
+			Message:   fmt.Sprintf("%d 名侦察兵被敌方发现，全部阵亡", scouts),
+		})
+		B.state.AddReport(&Report{
+			UserID:    target.UserID,
+			Type:      ReportScout,
+			Title:     "发现敌方侦察 " + target.Name,
+			CreatedAt: now,
+			Attacker:  B.state.newReportSide(m.UserID, nil, m.Troops, losses),
+			Message:   fmt.Sprintf("击退了来自 (%d,%d) 的 %d 名侦察兵", m.FromX, m.FromY, scouts),
+		})
+		delete(B.state.Marches, m.ID)
+		return
+	}
+
+	// 侦察成功
+	losses := 0
+	if defenderScouts > 0 {
+		ratio := float64(defenderScouts) / float64(scouts)
+		losses = min(int(math.Round(float64(scouts)*math.Pow(ratio, 1.5))), scouts-1)
+	}
+	if losses > 0 {
+		applyLossRatio(m.Troops, float64(losses)/float64(scouts), 0)
+		m.Troops = compactTroops(m.Troops)
+	}
+
+	log.Infof("Scout succeeded: march=%d, target_city=%d, scouts=%d, losses=%d",
+		m.ID, target.ID, scouts, losses)
+	B.state.AddReport(&Report{
+		UserID:    m.UserID,
+		Type:      ReportScout,
+		Title:     "侦察 " + target.Name + "（成功）",
+		CreatedAt: now,
+		Defender:  B.state.newReportSide(target.UserID, target, nil, nil),
+		Message:   fmt.Sprintf("侦察成功，损失 %d 名侦察兵", losses),
+		Intel:     collectIntel(target),
+	})
+	B.returnMarch(m)
+}
+
+// collectIntel 收集城池情报
+func collectIntel(city *City) *ScoutIntel {
+	intel := &ScoutIntel{
+		Resources: Resources{
+			Wood:  city.Wood,
+			Stone: city.Stone,
+			Iron:  city.Iron,
+			Food:  city.Food,
+			Gold:  city.Gold,
+		},
+		Troops:    []*Troop{},
+		Buildings: make(map[BuildingType]int),
+	}
+	for _, t := range city.DefendingTroops() {
+		intel.Troops = addTroopTo(intel.Troops, t.Type, t.Quantity)
+	}
+	for _, b := range city.GetAllBuildings() {
+		if b != nil {
+			intel.Buildings[b.Type] = b.Level
+		}
+	}
+	return intel
+}
@@ -0,0 +1,136 @@
+package beaconImp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"beacon/config"
+	"beacon/log"
+)
+
+// ========== Settle - 拓荒建城 ==========
+//
+// 含有拓荒部队（role = "settler"）的行军到达空地后，消耗一名拓荒部队建立新城池，
+// 其余部队返回出发城池。玩家城池数量受官府等级限制（取所有城池中最高的官府等级）。
+
+// settlerStartingResources 拓荒部队为新城池携带的初始资源
+var settlerStartingResources = Resources{Wood: 1000, Stone: 1000, Iron: 1000, Food: 1000}
+
+// CalcUserCityLimit 计算玩家城池数量上限
+func (B *Beacon) CalcUserCityLimit(userID uint) int {
+	limit := 1
+	for _, city := range B.state.ListCitiesByUser(userID) {
+		government := city.GetBuildingByType(BuildingGovernment)
+		if government == nil {
+			continue
+		}
+		conf := config.GetBuildingLevel(string(BuildingGovernment), government.Level)
+		if conf != nil && conf.CityLimit > limit {
+			limit = conf.CityLimit
+		}
+	}
+	return limit
+}
+
+// countPendingSettles 统计玩家正在前往目标的拓荒行军
+func (B *Beacon) countPendingSettles(userID uint) int {
+	count := 0
+	for _, m := range B.state.Marches {
+		if m.UserID == userID && m.Type == MarchSettle && m.Status == MarchOutbound {
+			count++
+		}
+	}
+	return count
+}
+
+// validateSettle 派出拓荒行军前的校验
+func (B *Beacon) validateSettle(city *City, toX, toY int, troops []*Troop) error {
+	hasSettler := false
+	for _, t := range troops {
+		if HasTroopRole(t.Type, config.TroopRoleSettler) && t.Quantity > 0 {
+			hasSettler = true
+		}
+	}
+	if !hasSettler {
+		return errors.New("拓荒需要拓荒部队")
+	}
+	if !B.state.WorldMap().IsFree(toX, toY) {
+		return errors.New("目标地块无法建城")
+	}
+
+	cityCount := len(B.state.ListCitiesByUser(city.UserID)) + B.countPendingSettles(city.UserID)
+	if cityCount >= B.CalcUserCityLimit(city.UserID) {
+		return errors.New("城池数量已达上限，请提升官府等级")
+	}
+	return nil
+}
+
+// resolveSettle 拓荒行军到达：在空地建立新城池
+func (B *Beacon) resolveSettle(m *March) {
+	err := B.foundCity(m)
+	if err != nil {
+		log.Infof("Settle failed: march=%d, target=(%d,%d), err=%v", m.ID, m.ToX, m.ToY, err)
+		B.addSettleReport(m, fmt.Sprintf("拓荒 (%d,%d) 失败：%s", m.ToX, m.ToY, err.Error()))
+	}
+
+	if len(m.Troops) == 0 {
+		delete(B.state.Marches, m.ID)
+		return
+	}
+	B.returnMarch(m)
+}
+
+// foundCity 消耗一名拓荒部队，在行军目标处建立新城池
+func (B *Beacon) foundCity(m *March) error {
+	if !B.state.WorldMap().IsFree(m.ToX, m.ToY) {
+		return errors.New("目标地块已被占据")
+	}
+	// 其他城池可能在行军途中被建立，到达时重新检查上限
+	if len(B.state.ListCitiesByUser(m.UserID)) >= B.CalcUserCityLimit(m.UserID) {
+		return errors.New("城池数量已达上限")
+	}
+
+	user, err := B.state.GetUserByID(m.UserID)
+	if err != nil {
+		return errors.New("玩家不存在")
+	}
+
+	city := &City{
+		UserID: m.UserID,
+		Name:   fmt.Sprintf("新城池%d", len(user.CityIDs)+1),
+		PosX:   m.ToX,
+		PosY:   m.ToY,
+	}
+	city.AddResources(settlerStartingResources)
+	city.initBuildings(0)
+
+	if err := B.state.CreateCity(city); err != nil {
+		return errors.New("目标地块无法建城")
+	}
+
+	// 消耗一名拓荒部队
+	for _, t := range m.Troops {
+		if HasTroopRole(t.Type, config.TroopRoleSettler) {
+			t.Quantity--
+			break
+		}
+	}
+	m.Troops = compactTroops(m.Troops)
+
+	log.Infof("City founded: city=%d, user=%d, pos=(%d,%d), march=%d",
+		city.ID, city.UserID, city.PosX, city.PosY, m.ID)
+	B.addSettleReport(m, fmt.Sprintf("在 (%d,%d) 建立了新城池「%s」", city.PosX, city.PosY, city.Name))
+	return nil
+}
+
+// addSettleReport 为拓荒玩家生成战报
+func (B *Beacon) addSettleReport(m *March, message string) {
+	B.state.AddReport(&Report{
+		UserID:    m.UserID,
+		Type:      ReportSettle,
+		Title:     fmt.Sprintf("拓荒 (%d,%d)", m.ToX, m.ToY),
+		CreatedAt: time.Now().Unix(),
+		Message:   message,
+	})
+}
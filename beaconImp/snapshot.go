@@ -1,90 +1,590 @@
 package beaconImp
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"beacon/config"
 	"beacon/log"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 )
 
 const (
 	snapshotDir   = "./data/snapshots"
 	latestSymlink = "./data/latest"
-	maxSnapshots  = 10 // 保留最近10个快照
+
+	snapshotTimeLayout = "2006-01-02_15-04-05" // 快照文件名中的时间格式（本地时间）
 )
 
 // ========== Snapshot I/O - 快照持久化管理 ==========
 
-// SaveSnapshot 保存当前游戏状态到快照文件
-// 注意：调用者需持有读锁（保证状态一致性）
-func (B *Beacon) SaveSnapshot() error {
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	filename := fmt.Sprintf("snapshot_%s.json", timestamp)
+// SaveSnapshot 将提取的快照数据写入存储后端（无需持锁）
+func (B *Beacon) SaveSnapshot(snap *StateSnapshot) error {
+	if snap.Full {
+		return B.storage.Save(snap)
+	}
+	return B.storage.SaveChanges(snap)
+}
+
+// LoadLatestSnapshot 通过存储后端加载最新状态到内存，并重放命令日志中快照之后的操作
+func (B *Beacon) LoadLatestSnapshot() error {
+	state, err := B.storage.Load()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		log.Info("No existing snapshot found, starting with empty state")
+		state = NewGameState()
+	}
+
+	state.RebuildWorldMap()
+	state.RebuildUserIndex()
+	for _, city := range state.Cities {
+		// 补齐配置中新增的建筑类型
+		city.initBuildings(0)
+		B.fixBuildingQueueTargets(city)
+	}
+	// 兼容处理可能修改任意实体，首次保存时全部写入
+	state.markAllDirty()
+	B.state = state
+
+	validSize, err := B.replayWAL()
+	if err != nil {
+		return err
+	}
+	return B.openWAL(validSize)
+}
+
+// ========== JSON 快照后端 ==========
+//
+// 全量快照 snapshot_<时间>.json 包含全部玩家、城池、行军和战报；
+// 增量快照 snapshot_<全量时间>.delta_<时间>.json 包含自该全量快照以来变化过的全部实体（累积，
+// 已结束的行军和已清空的战报记为 null），
+// 因此加载时只需要全量快照 + 最新一个增量快照。每 compactEvery 次增量后重新写出全量快照（合并），
+// latest 软链始终指向最近写出的快照文件。
+// 最新快照损坏时加载会回退到上一个快照：新快照写出并校验后，上一个快照保留为回退快照（其全量快照不被清理），
+// 命令日志也只清理回退快照已包含的命令，回退后重放日志即可恢复全部进度；更早的增量快照随之删除。
+// 启用压缩时文件名追加 .gz / .zst 后缀，旧的全量快照按 conf/server.toml 中的分级保留策略清理。
+
+// snapshotFile 快照文件内容（全量和增量共用）
+type snapshotFile struct {
+	Base    string                   `json:"base,omitempty"` // 增量快照所基于的全量快照文件名（全量快照为空）
+	World   json.RawMessage          `json:"world"`          // 全局数据
+	Users   map[uint]json.RawMessage `json:"users"`          // 玩家ID -> 玩家
+	Cities  map[uint]json.RawMessage `json:"cities"`         // 城池ID -> 城池
+	Marches map[uint]json.RawMessage `json:"marches"`        // 行军ID -> 行军
+	Reports map[uint]json.RawMessage `json:"reports"`        // 玩家ID -> 战报列表
+}
+
+// jsonSnapshotStorage JSON 快照后端
+type jsonSnapshotStorage struct {
+	compactEvery int
+	compression  string                   // 快照压缩算法
+	retention    config.RetentionConf     // 全量快照保留策略
+	clock        func() time.Time         // 当前时间（快照文件名和保留策略使用）
+	base         string                   // 当前全量快照文件名（为空时下次保存需要全量）
+	latest       string                   // 最近写出（或启动时加载）的快照文件名
+	latestSeq    uint64                   // latest 包含的最后一条命令序号
+	fallback     string                   // 回退快照：latest 之前的快照文件名
+	fallbackSeq  uint64                   // fallback 包含的最后一条命令序号
+	deltas       int                      // 当前全量快照之后已保存的增量次数
+	users        map[uint]json.RawMessage // 全量快照之后变化过的玩家（增量快照内容）
+	cities       map[uint]json.RawMessage // 全量快照之后变化过的城池
+	marches      map[uint]json.RawMessage // 全量快照之后变化过的行军
+	reports      map[uint]json.RawMessage // 全量快照之后变化过的战报
+}
+
+// newJSONSnapshotStorage 创建 JSON 快照后端
+func newJSONSnapshotStorage(conf config.StorageConf) (*jsonSnapshotStorage, error) {
+	// 创建快照目录
+	if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+		return nil, fmt.Errorf("create snapshot dir: %w", err)
+	}
+	return &jsonSnapshotStorage{
+		compactEvery: conf.CompactEvery,
+		compression:  conf.Compression,
+		retention:    conf.Retention,
+		clock:        time.Now,
+	}, nil
+}
+
+// NeedFull 启动后首次保存，或增量次数达到合并间隔时需要全量快照
+func (s *jsonSnapshotStorage) NeedFull() bool {
+	return s.base == "" || s.deltas >= s.compactEvery
+}
+
+// Save 写出全量快照
+func (s *jsonSnapshotStorage) Save(snap *StateSnapshot) error {
+	filename := fmt.Sprintf("snapshot_%s%s", s.clock().Format(snapshotTimeLayout), snapshotExt(s.compression))
+	file := &snapshotFile{
+		World:   snap.World,
+		Users:   make(map[uint]json.RawMessage, len(snap.Users)),
+		Cities:  make(map[uint]json.RawMessage, len(snap.Cities)),
+		Marches: snap.Marches,
+		Reports: snap.Reports,
+	}
+	for id, u := range snap.Users {
+		file.Users[id] = u.Data
+	}
+	for id, c := range snap.Cities {
+		file.Cities[id] = c.Data
+	}
+	if err := writeSnapshotFile(filename, file, s.compression); err != nil {
+		return err
+	}
+	s.recordSaved(filename, snap.LastCommandSeq)
+
+	s.base = filename
+	s.deltas = 0
+	s.users = make(map[uint]json.RawMessage)
+	s.cities = make(map[uint]json.RawMessage)
+	s.marches = make(map[uint]json.RawMessage)
+	s.reports = make(map[uint]json.RawMessage)
+
+	// 清理旧快照（保留回退快照）
+	if err := rotateSnapshots(s.retention, s.clock(), s.fallback); err != nil {
+		log.Warnf("Failed to rotate snapshots: %v", err)
+	}
+
+	log.Infof("Snapshot saved: %s (%d users, %d cities, %d marches)",
+		filename, len(file.Users), len(file.Cities), len(file.Marches))
+	return nil
+}
+
+// SaveChanges 写出增量快照（累积自全量快照以来的全部变化）
+func (s *jsonSnapshotStorage) SaveChanges(snap *StateSnapshot) error {
+	if s.base == "" {
+		return fmt.Errorf("delta snapshot without base snapshot")
+	}
+	for id, u := range snap.Users {
+		s.users[id] = u.Data
+	}
+	for id, c := range snap.Cities {
+		s.cities[id] = c.Data
+	}
+	for id, m := range snap.Marches {
+		s.marches[id] = m
+	}
+	for userID, r := range snap.Reports {
+		s.reports[userID] = r
+	}
+
+	baseStem, _ := snapshotStem(s.base)
+	filename := fmt.Sprintf("%s.delta_%s%s",
+		baseStem, s.clock().Format(snapshotTimeLayout), snapshotExt(s.compression))
+	file := &snapshotFile{
+		Base:    s.base,
+		World:   snap.World,
+		Users:   s.users,
+		Cities:  s.cities,
+		Marches: s.marches,
+		Reports: s.reports,
+	}
+	if err := writeSnapshotFile(filename, file, s.compression); err != nil {
+		return err
+	}
+	s.recordSaved(filename, snap.LastCommandSeq)
+	s.deltas++
+
+	log.Debugf("Delta snapshot saved: %s (changed %d users, %d cities, %d marches; %d users, %d cities, %d marches since %s)",
+		filename, len(snap.Users), len(snap.Cities), len(snap.Marches),
+		len(s.users), len(s.cities), len(s.marches), s.base)
+	return nil
+}
+
+// Load 加载最新的可用快照：优先 latest 软链指向的文件，文件损坏（校验失败、被截断、无法解析）时
+// 按时间从新到旧尝试 snapshotDir 中的其他快照
+func (s *jsonSnapshotStorage) Load() (*GameState, error) {
+	candidates, err := snapshotCandidates()
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	for i, name := range candidates {
+		state, err := loadSnapshot(name)
+		if errors.Is(err, errSchemaTooNew) {
+			return nil, fmt.Errorf("load snapshot %s: %w", name, err)
+		}
+		if err != nil {
+			log.Errorf("Snapshot %s is unusable: %v", name, err)
+			continue
+		}
+		if i > 0 {
+			log.Warnf("Fell back to snapshot %s, later progress is recovered from the wal", name)
+		}
+		log.Infof("Loaded snapshot from: %s", name)
+		s.latest, s.latestSeq = name, state.LastCommandSeq
+		return state, nil
+	}
+	return nil, fmt.Errorf("no usable snapshot in %s (%d files)", snapshotDir, len(candidates))
+}
+
+// FallbackSeq 回退快照包含的最后一条命令序号
+func (s *jsonSnapshotStorage) FallbackSeq() uint64 {
+	return s.fallbackSeq
+}
+
+// recordSaved 新快照已写出并校验：latest 成为回退快照，之前的回退增量快照已不再需要
+func (s *jsonSnapshotStorage) recordSaved(filename string, seq uint64) {
+	if filename == s.latest {
+		// 同一秒内写出的同名快照覆盖了 latest，回退快照不变
+		s.latestSeq = seq
+		return
+	}
+	if s.fallback != "" && isDeltaSnapshot(s.fallback) {
+		if err := os.Remove(filepath.Join(snapshotDir, s.fallback)); err != nil {
+			log.Warnf("Failed to remove superseded delta snapshot %s: %v", s.fallback, err)
+		}
+	}
+	s.fallback, s.fallbackSeq = s.latest, s.latestSeq
+	s.latest, s.latestSeq = filename, seq
+}
+
+// loadSnapshot 加载快照文件（增量快照先加载其全量快照再覆盖变化的实体），迁移到当前存档版本后解码
+func loadSnapshot(name string) (*GameState, error) {
+	file, version, err := loadSnapshotFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := &rawSnapshot{World: file.World, Users: file.Users, Cities: file.Cities, Marches: file.Marches, Reports: file.Reports}
+	if file.Base != "" {
+		base, baseVersion, err := loadSnapshotFile(file.Base)
+		if err != nil {
+			return nil, fmt.Errorf("base snapshot %s: %w", file.Base, err)
+		}
+		if baseVersion != version {
+			return nil, fmt.Errorf("base snapshot %s has schema %d, delta has %d", file.Base, baseVersion, version)
+		}
+		overlayEntities(base.Users, file.Users)
+		overlayEntities(base.Cities, file.Cities)
+		overlayEntities(base.Marches, file.Marches)
+		overlayEntities(base.Reports, file.Reports)
+		raw = &rawSnapshot{World: file.World, Users: base.Users, Cities: base.Cities, Marches: base.Marches, Reports: base.Reports}
+	}
+
+	if err := migrateSnapshot(version, raw); err != nil {
+		return nil, err
+	}
+	return decodeState(raw)
+}
+
+// overlayEntities 用增量快照中的实体覆盖全量快照（null 表示已删除，解码时跳过）
+func overlayEntities(base, delta map[uint]json.RawMessage) {
+	for id, data := range delta {
+		base[id] = data
+	}
+}
+
+// snapshotCandidates 列出可加载的快照：latest 软链指向的文件在前，其余按时间从新到旧
+func snapshotCandidates() ([]string, error) {
+	var candidates []string
+	latestPath, err := os.Readlink(latestSymlink)
+	if err == nil {
+		candidates = append(candidates, filepath.Base(latestPath))
+	} else if !os.IsNotExist(err) {
+		log.Warnf("Failed to read latest symlink: %v", err)
+	}
+
+	entries, err := os.ReadDir(snapshotDir)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot dir: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && isSnapshotFile(e.Name()) && (len(candidates) == 0 || e.Name() != candidates[0]) {
+			names = append(names, e.Name())
+		}
+	}
+	// 同一时刻写出的增量快照比全量快照新
+	sort.Slice(names, func(i, j int) bool {
+		ti, tj := snapshotTime(names[i]), snapshotTime(names[j])
+		if ti != tj {
+			return ti > tj
+		}
+		return isDeltaSnapshot(names[i]) && !isDeltaSnapshot(names[j])
+	})
+	return append(candidates, names...), nil
+}
+
+// snapshotTime 快照文件名中的写出时间（增量快照取增量部分的时间）
+func snapshotTime(name string) string {
+	name, _ = snapshotStem(name)
+	if i := strings.Index(name, ".delta_"); i >= 0 {
+		return name[i+len(".delta_"):]
+	}
+	return strings.TrimPrefix(name, "snapshot_")
+}
+
+// Close 无需释放资源
+func (s *jsonSnapshotStorage) Close() error {
+	return nil
+}
+
+// writeSnapshotFile 写入快照文件（临时文件 + fsync + 原子重命名）并更新 latest 软链
+func writeSnapshotFile(filename string, file *snapshotFile, compression string) error {
 	filePath := filepath.Join(snapshotDir, filename)
 
-	// 序列化状态
-	data, err := json.MarshalIndent(B.state, "", "  ")
+	body, err := json.Marshal(file)
 	if err != nil {
-		return fmt.Errorf("marshal state: %w", err)
+		return fmt.Errorf("marshal snapshot: %w", err)
+	}
+	data, err := encodeSnapshotData(body, compression)
+	if err != nil {
+		return err
 	}
 
-	// 写入临时文件
+	// 写入临时文件并刷盘（快照保存成功后命令日志会被清理）
 	tmpFile := filePath + ".tmp"
-	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return fmt.Errorf("create tmp file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
 		return fmt.Errorf("write tmp file: %w", err)
 	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync tmp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close tmp file: %w", err)
+	}
 
 	// 原子性重命名
 	if err := os.Rename(tmpFile, filePath); err != nil {
 		return fmt.Errorf("rename snapshot: %w", err)
 	}
 
-	// 更新 latest 软链
-	if err := updateLatestSymlink(filePath); err != nil {
-		log.Warnf("Failed to update latest symlink: %v", err)
+	// 重新读取校验，确认写出的快照可用后才能删除旧快照、清理命令日志
+	if _, _, err := loadSnapshotFile(filename); err != nil {
+		return fmt.Errorf("verify snapshot: %w", err)
 	}
 
-	// 清理旧快照
-	if err := rotateSnapshots(); err != nil {
-		log.Warnf("Failed to rotate snapshots: %v", err)
+	// 更新 latest 软链（失败时不能清空命令日志，否则重启会回到旧快照）
+	if err := updateLatestSymlink(filePath); err != nil {
+		return fmt.Errorf("update latest symlink: %w", err)
 	}
-
-	log.Infof("Snapshot saved: %s", filename)
 	return nil
 }
 
-// LoadLatestSnapshot 加载最新快照到内存
-func (B *Beacon) LoadLatestSnapshot() error {
-	// 检查 latest 软链是否存在
-	latestPath, err := os.Readlink(latestSymlink)
+// loadSnapshotFile 读取并校验 snapshotDir 中的快照文件，返回快照内容和存档版本（尚未迁移）
+func loadSnapshotFile(filename string) (*snapshotFile, int, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, filename))
+	if err != nil {
+		return nil, 0, err
+	}
+	version, body, err := decodeSnapshotData(data)
 	if err != nil {
-		if os.IsNotExist(err) {
-			log.Info("No existing snapshot found, starting with empty state")
-			B.state = NewGameState()
-			return nil
+		return nil, 0, err
+	}
+
+	var probe struct {
+		World json.RawMessage `json:"world"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, 0, fmt.Errorf("unmarshal snapshot: %w", err)
+	}
+	file := &snapshotFile{}
+	if probe.World == nil {
+		// 最早的格式：整个文件就是 GameState
+		var raw *rawSnapshot
+		raw, err = splitLegacyState(body)
+		if err == nil {
+			file.World, file.Users, file.Cities = raw.World, raw.Users, raw.Cities
+		}
+	} else {
+		err = json.Unmarshal(body, file)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("unmarshal snapshot: %w", err)
+	}
+	for _, m := range []*map[uint]json.RawMessage{&file.Users, &file.Cities, &file.Marches, &file.Reports} {
+		if *m == nil {
+			*m = make(map[uint]json.RawMessage)
 		}
-		return fmt.Errorf("read latest symlink: %w", err)
 	}
+	return file, version, nil
+}
 
-	// 读取快照文件
-	data, err := os.ReadFile(latestPath)
+// ========== 快照文件头 ==========
+//
+// 快照文件第一行为文件头（不压缩），之后是快照正文（按 codec 压缩后的 JSON）：
+//
+//	BEACON-SNAPSHOT schema=<存档版本> codec=<压缩算法> size=<正文字节数> sha256=<正文校验和>
+//
+// 长度和校验和针对写入磁盘的（压缩后的）正文，加载时先校验再解压，发现文件被截断或损坏。
+// 没有文件头的早期快照按版本1处理，没有 codec 的文件头表示正文未压缩。
+
+const snapshotMagic = "BEACON-SNAPSHOT"
+
+// encodeSnapshotData 压缩快照正文并加上文件头
+func encodeSnapshotData(body []byte, compression string) ([]byte, error) {
+	payload, err := compressSnapshot(body, compression)
 	if err != nil {
-		return fmt.Errorf("read snapshot: %w", err)
+		return nil, err
 	}
+	sum := sha256.Sum256(payload)
+	header := fmt.Sprintf("%s schema=%d codec=%s size=%d sha256=%s\n",
+		snapshotMagic, currentSchemaVersion, compression, len(payload), hex.EncodeToString(sum[:]))
+	return append([]byte(header), payload...), nil
+}
 
-	// 反序列化
-	state := NewGameState()
-	if err := json.Unmarshal(data, state); err != nil {
-		return fmt.Errorf("unmarshal snapshot: %w", err)
+// decodeSnapshotData 解析并校验文件头，返回存档版本和解压后的快照正文
+func decodeSnapshotData(data []byte) (int, []byte, error) {
+	if !bytes.HasPrefix(data, []byte(snapshotMagic+" ")) {
+		return schemaVersionLegacyBuildings, data, nil
+	}
+	line, payload, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return 0, nil, fmt.Errorf("truncated snapshot header")
+	}
+	header := map[string]string{"codec": config.CompressionNone}
+	for _, field := range strings.Fields(string(line))[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		header[key] = value
 	}
 
-	B.state = state
-	log.Infof("Loaded snapshot from: %s", latestPath)
-	return nil
+	version, err := strconv.Atoi(header["schema"])
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid schema version %q", header["schema"])
+	}
+	size, err := strconv.Atoi(header["size"])
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid snapshot size %q", header["size"])
+	}
+	if len(payload) != size {
+		return 0, nil, fmt.Errorf("snapshot size mismatch: header %d, actual %d", size, len(payload))
+	}
+	sum := sha256.Sum256(payload)
+	if hex.EncodeToString(sum[:]) != header["sha256"] {
+		return 0, nil, fmt.Errorf("snapshot checksum mismatch")
+	}
+
+	body, err := decompressSnapshot(payload, header["codec"])
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, body, nil
+}
+
+// ========== 快照压缩 ==========
+
+// zstd 编解码器（EncodeAll/DecodeAll 可并发使用；不带选项创建时不会出错）
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// compressSnapshot 按压缩算法压缩快照正文
+func compressSnapshot(body []byte, compression string) ([]byte, error) {
+	switch compression {
+	case config.CompressionNone:
+		return body, nil
+	case config.CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, fmt.Errorf("gzip snapshot: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("gzip snapshot: %w", err)
+		}
+		return buf.Bytes(), nil
+	case config.CompressionZstd:
+		return zstdEncoder.EncodeAll(body, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown snapshot compression %q", compression)
+	}
+}
+
+// decompressSnapshot 按文件头记录的压缩算法解压快照正文
+func decompressSnapshot(payload []byte, codec string) ([]byte, error) {
+	switch codec {
+	case config.CompressionNone:
+		return payload, nil
+	case config.CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("gunzip snapshot: %w", err)
+		}
+		defer r.Close()
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("gunzip snapshot: %w", err)
+		}
+		return body, nil
+	case config.CompressionZstd:
+		body, err := zstdDecoder.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, fmt.Errorf("zstd decode snapshot: %w", err)
+		}
+		return body, nil
+	default:
+		return nil, fmt.Errorf("unknown snapshot codec %q", codec)
+	}
+}
+
+// snapshotExt 快照文件扩展名
+func snapshotExt(compression string) string {
+	switch compression {
+	case config.CompressionGzip:
+		return ".json.gz"
+	case config.CompressionZstd:
+		return ".json.zst"
+	default:
+		return ".json"
+	}
+}
+
+// snapshotStem 去掉快照文件名的扩展名，不是快照文件时返回 false
+func snapshotStem(name string) (string, bool) {
+	if !strings.HasPrefix(name, "snapshot_") {
+		return "", false
+	}
+	for _, ext := range []string{".json", ".json.gz", ".json.zst"} {
+		if stem, ok := strings.CutSuffix(name, ext); ok {
+			return stem, true
+		}
+	}
+	return "", false
+}
+
+// fullSnapshotStem 快照所属的全量快照文件名（去掉扩展名），增量快照取其全量快照
+func fullSnapshotStem(name string) string {
+	stem, _ := snapshotStem(name)
+	if i := strings.Index(stem, ".delta_"); i >= 0 {
+		return stem[:i]
+	}
+	return stem
+}
+
+// isSnapshotFile 文件名是否为快照文件（全量或增量，任意压缩算法）
+func isSnapshotFile(name string) bool {
+	_, ok := snapshotStem(name)
+	return ok
+}
+
+// isDeltaSnapshot 文件名是否为增量快照
+func isDeltaSnapshot(name string) bool {
+	return strings.Contains(name, ".delta_")
 }
 
 // updateLatestSymlink 更新 latest 软链指向最新快照
@@ -96,35 +596,86 @@ func updateLatestSymlink(targetPath string) error {
 	return os.Symlink(targetPath, latestSymlink)
 }
 
-// rotateSnapshots 清理旧快照，保留最近N个
-func rotateSnapshots() error {
+// rotateSnapshots 按分级保留策略清理旧的全量快照，删除全量快照时一并删除其增量快照
+// protect 所属的全量快照（回退快照需要）始终保留
+func rotateSnapshots(retention config.RetentionConf, now time.Time, protect string) error {
 	entries, err := os.ReadDir(snapshotDir)
 	if err != nil {
 		return err
 	}
 
-	// 过滤出快照文件
+	// 过滤出全量快照文件
 	var snapshots []string
 	for _, e := range entries {
-		if !e.IsDir() && filepath.Ext(e.Name()) == ".json" {
+		if !e.IsDir() && isSnapshotFile(e.Name()) && !isDeltaSnapshot(e.Name()) {
 			snapshots = append(snapshots, e.Name())
 		}
 	}
 
-	// 按时间戳排序（文件名已包含时间戳）
-	sort.Strings(snapshots)
+	// 按时间从新到旧排序
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshotTime(snapshots[i]) > snapshotTime(snapshots[j])
+	})
 
-	// 删除多余的旧快照
-	if len(snapshots) > maxSnapshots {
-		for i := 0; i < len(snapshots)-maxSnapshots; i++ {
-			oldPath := filepath.Join(snapshotDir, snapshots[i])
-			if err := os.Remove(oldPath); err != nil {
-				log.Warnf("Failed to remove old snapshot %s: %v", snapshots[i], err)
-			} else {
-				log.Infof("Removed old snapshot: %s", snapshots[i])
+	keep := retainedSnapshots(snapshots, retention, now)
+	if protect != "" {
+		protectStem := fullSnapshotStem(protect)
+		for _, name := range snapshots {
+			if stem, _ := snapshotStem(name); stem == protectStem {
+				keep[name] = true
 			}
 		}
 	}
-
+	for _, name := range snapshots {
+		if !keep[name] {
+			removeSnapshot(name, entries)
+		}
+	}
 	return nil
 }
+
+// retainedSnapshots 选出保留的全量快照（names 按时间从新到旧）：
+// 最新的快照、keep_all 内的全部快照，以及每级窗口内每个小时/每天最新的一个快照
+func retainedSnapshots(names []string, retention config.RetentionConf, now time.Time) map[string]bool {
+	keepAll := time.Duration(retention.KeepAllMinutes) * time.Minute
+	hourly := time.Duration(retention.HourlyHours) * time.Hour
+	daily := time.Duration(retention.DailyDays) * 24 * time.Hour
+
+	keep := make(map[string]bool)
+	keptHours := make(map[string]bool) // 已有保留快照的小时
+	keptDays := make(map[string]bool)  // 已有保留快照的日期
+	for i, name := range names {
+		t, err := time.ParseInLocation(snapshotTimeLayout, snapshotTime(name), time.Local)
+		if err != nil {
+			// 无法识别时间的文件不删除
+			keep[name] = true
+			continue
+		}
+		age := now.Sub(t)
+		hour, day := t.Format("2006-01-02_15"), t.Format("2006-01-02")
+		if i == 0 || age < keepAll ||
+			(age < hourly && !keptHours[hour]) ||
+			(age < daily && !keptDays[day]) {
+			keep[name] = true
+			keptHours[hour] = true
+			keptDays[day] = true
+		}
+	}
+	return keep
+}
+
+// removeSnapshot 删除全量快照及基于它的增量快照
+func removeSnapshot(name string, entries []os.DirEntry) {
+	stem, _ := snapshotStem(name)
+	deltaPrefix := stem + ".delta_"
+	for _, e := range entries {
+		if e.Name() != name && !strings.HasPrefix(e.Name(), deltaPrefix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(snapshotDir, e.Name())); err != nil {
+			log.Warnf("Failed to remove old snapshot %s: %v", e.Name(), err)
+		} else {
+			log.Infof("Removed old snapshot: %s", e.Name())
+		}
+	}
+}
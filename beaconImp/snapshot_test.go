@@ -0,0 +1,271 @@
+package beaconImp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"beacon/config"
+)
+
+// corruptLatestSnapshot 修改 latest 软链指向的快照文件的最后一个字节（校验和不再匹配）
+func corruptLatestSnapshot(t *testing.T) string {
+	t.Helper()
+	target, err := os.Readlink(latestSymlink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(target, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Base(target)
+}
+
+func TestLoadFallsBackToPreviousSnapshot(t *testing.T) {
+	// testStorageConf 每3次增量合并一次：第5次保存为全量快照，第6次为增量快照
+	for _, tc := range []struct {
+		name  string
+		saves int
+	}{
+		{"latest full", 5},
+		{"latest delta", 6},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			B := newTestBeacon(t)
+			storage := B.storage.(*jsonSnapshotStorage)
+			// 只保留最新的全量快照，回退快照的全量快照也不能被清理
+			storage.retention.KeepAllMinutes = 0
+			clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+			storage.clock = func() time.Time {
+				clock = clock.Add(10 * time.Second)
+				return clock
+			}
+			now := time.Unix(1700000000, 0)
+
+			mustRun(t, B, 0, &RegisterCmd{Username: "a", HashedPassword: "x"})
+			user, err := B.state.GetUserByUsername("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			city := B.state.ListCitiesByUser(user.ID)[0]
+			buildings := []BuildingType{"lumberyard", "farm", "quarry", "iron_mine", "warehouse", "government", "barracks"}
+			for i := 0; i < tc.saves; i++ {
+				mustRun(t, B, user.ID, &UpgradeBuildingCmd{CityID: city.ID, BuildingType: buildings[i%len(buildings)]})
+				for j := 0; j < 10; j++ {
+					B.advanceWallTime(1, now)
+				}
+				saveTestSnapshot(t, B)
+			}
+			// 最新快照之后的命令只在日志中
+			mustRun(t, B, user.ID, &RecruitCmd{CityID: city.ID, TroopType: "spear_shield", Quantity: 1})
+			want := encodeTestState(t, B.state)
+			B.wal.Close()
+
+			corrupted := corruptLatestSnapshot(t)
+			if isDeltaSnapshot(corrupted) != (tc.name == "latest delta") {
+				t.Fatalf("latest snapshot %s is not a %s", corrupted, tc.name)
+			}
+			restarted := openTestBeacon(t)
+			if got := encodeTestState(t, restarted.state); got != want {
+				t.Fatalf("recovered state differs from live state\nlive:      %s\nrecovered: %s", want, got)
+			}
+		})
+	}
+}
+
+func TestSnapshotDataRoundTrip(t *testing.T) {
+	body := []byte(`{"world":{"elapsed":1}}`)
+	for _, compression := range []string{config.CompressionNone, config.CompressionGzip, config.CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			data, err := encodeSnapshotData(body, compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, _, _ := bytes.Cut(data, []byte("\n"))
+			if !strings.Contains(string(header), " codec="+compression+" ") {
+				t.Fatalf("header %q does not record codec %s", header, compression)
+			}
+			version, got, err := decodeSnapshotData(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != currentSchemaVersion || !bytes.Equal(got, body) {
+				t.Fatalf("decoded schema %d body %s, want schema %d body %s", version, got, currentSchemaVersion, body)
+			}
+		})
+	}
+
+	// 没有文件头的早期快照按版本1原样返回
+	version, got, err := decodeSnapshotData(body)
+	if err != nil || version != schemaVersionLegacyBuildings || !bytes.Equal(got, body) {
+		t.Fatalf("legacy data = schema %d body %s err %v", version, got, err)
+	}
+}
+
+// testSnapshotHeader 按正文计算长度和校验和生成文件头
+func testSnapshotHeader(codec string, payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return fmt.Sprintf("%s schema=%d codec=%s size=%d sha256=%s\n",
+		snapshotMagic, currentSchemaVersion, codec, len(payload), hex.EncodeToString(sum[:]))
+}
+
+func TestDecodeSnapshotDataRejectsDamagedFiles(t *testing.T) {
+	body := []byte(`{"world":{"elapsed":1}}`)
+	valid, err := encodeSnapshotData(body, config.CompressionGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerLen := bytes.IndexByte(valid, '\n') + 1
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"truncated payload", valid[:len(valid)-1]},
+		{"truncated header", valid[:headerLen-1]},
+		{"tampered payload", func() []byte {
+			data := bytes.Clone(valid)
+			data[headerLen] ^= 0xff
+			return data
+		}()},
+		{"tampered checksum", bytes.Replace(valid, []byte("sha256="), []byte("sha256=00"), 1)},
+		{"unknown codec", append([]byte(testSnapshotHeader("lz4", body)), body...)},
+		{"codec mismatch", append([]byte(testSnapshotHeader(config.CompressionZstd, body)), body...)},
+		{"invalid size", bytes.Replace(valid, []byte("size="), []byte("size=x"), 1)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := decodeSnapshotData(tc.data); err == nil {
+				t.Fatal("damaged snapshot was accepted")
+			}
+		})
+	}
+}
+
+func TestLoadSnapshotOverlaysDeltaOnBase(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	base := &snapshotFile{
+		World: json.RawMessage(`{"next_user_id":2,"next_city_id":3,"next_march_id":3,"elapsed":10}`),
+		Users: map[uint]json.RawMessage{1: json.RawMessage(`{"id":1,"username":"a","city_ids":[1,2]}`)},
+		Cities: map[uint]json.RawMessage{
+			1: json.RawMessage(`{"id":1,"user_id":1,"pos_x":1,"pos_y":1,"wood":1}`),
+			2: json.RawMessage(`{"id":2,"user_id":1,"pos_x":2,"pos_y":2,"wood":5}`),
+		},
+		Marches: map[uint]json.RawMessage{
+			1: json.RawMessage(`{"id":1,"user_id":1,"origin_city_id":1,"ends_at":20}`),
+			2: json.RawMessage(`{"id":2,"user_id":1,"origin_city_id":2,"ends_at":30}`),
+		},
+		Reports: map[uint]json.RawMessage{1: json.RawMessage(`[{"id":1,"user_id":1,"title":"r"}]`)},
+	}
+	delta := &snapshotFile{
+		Base:    "snapshot_2026-01-01_00-00-00.json",
+		World:   json.RawMessage(`{"next_user_id":2,"next_city_id":3,"next_march_id":4,"elapsed":15}`),
+		Cities:  map[uint]json.RawMessage{1: json.RawMessage(`{"id":1,"user_id":1,"pos_x":1,"pos_y":1,"wood":9}`)},
+		Marches: map[uint]json.RawMessage{1: json.RawMessage(`null`), 3: json.RawMessage(`{"id":3,"user_id":1,"origin_city_id":1,"ends_at":40}`)},
+		Reports: map[uint]json.RawMessage{1: json.RawMessage(`null`)},
+	}
+	if err := writeSnapshotFile(delta.Base, base, config.CompressionNone); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSnapshotFile("snapshot_2026-01-01_00-00-00.delta_2026-01-01_00-00-10.json.gz", delta, config.CompressionGzip); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := loadSnapshot("snapshot_2026-01-01_00-00-00.delta_2026-01-01_00-00-10.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Elapsed != 15 || state.NextMarchID != 4 {
+		t.Fatalf("world = elapsed %v, next march %d, want the delta's world", state.Elapsed, state.NextMarchID)
+	}
+	if state.Cities[1].Wood != 9 || state.Cities[2].Wood != 5 {
+		t.Fatalf("city wood = %v, %v, want 9 from the delta and 5 from the base", state.Cities[1].Wood, state.Cities[2].Wood)
+	}
+	if len(state.Marches) != 2 || state.Marches[1] != nil || state.Marches[2].EndsAt != 30 || state.Marches[3].EndsAt != 40 {
+		t.Fatalf("marches = %v, want 2 from the base and 3 from the delta", state.Marches)
+	}
+	if len(state.Reports[1]) != 0 {
+		t.Fatalf("reports of user 1 = %v, want none", state.Reports[1])
+	}
+	if _, err := state.GetUserByUsername("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 增量快照与其全量快照的存档版本不同时拒绝加载
+	writeTestSnapshotFile(t, "snapshot_2026-01-01_00-00-20.json", schemaVersionBuildingsMap, `{"world":{}}`)
+	delta.Base = "snapshot_2026-01-01_00-00-20.json"
+	if err := writeSnapshotFile("snapshot_2026-01-01_00-00-20.delta_2026-01-01_00-00-30.json", delta, config.CompressionNone); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSnapshot("snapshot_2026-01-01_00-00-20.delta_2026-01-01_00-00-30.json"); err == nil {
+		t.Fatal("delta over a base with another schema version was accepted")
+	}
+}
+
+func TestRetainedSnapshots(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 30, 0, 0, time.Local)
+	// snapshotAt 距 now 指定时长之前写出的全量快照文件名
+	snapshotAt := func(ago time.Duration) string {
+		return "snapshot_" + now.Add(-ago).Format(snapshotTimeLayout) + ".json"
+	}
+	// 按时间从新到旧
+	names := []string{
+		snapshotAt(2 * time.Minute),            // 12:28
+		snapshotAt(20 * time.Minute),           // 12:10
+		snapshotAt(45 * time.Minute),           // 11:45
+		snapshotAt(80 * time.Minute),           // 11:10
+		snapshotAt(100 * time.Minute),          // 10:50
+		snapshotAt(110 * time.Minute),          // 10:40
+		snapshotAt(5 * time.Hour),              // 07:30
+		snapshotAt(30 * time.Hour),             // 前一天 06:30
+		snapshotAt(33 * time.Hour),             // 前一天 03:30
+		snapshotAt(3*24*time.Hour + time.Hour), // 3天前 11:30
+		snapshotAt(10 * 24 * time.Hour),        // 10天前
+		"snapshot_unknown.json",                // 无法识别时间
+	}
+
+	for _, tc := range []struct {
+		name      string
+		retention config.RetentionConf
+		names     []string
+		want      []int // 保留的 names 下标
+	}{
+		{"keep all", config.RetentionConf{KeepAllMinutes: 60}, names, []int{0, 1, 2, 11}},
+		{"hourly", config.RetentionConf{HourlyHours: 6}, names, []int{0, 2, 4, 6, 11}},
+		{"daily", config.RetentionConf{DailyDays: 5}, names, []int{0, 7, 9, 11}},
+		{"tiered", config.RetentionConf{KeepAllMinutes: 30, HourlyHours: 3, DailyDays: 5}, names, []int{0, 1, 2, 4, 7, 9, 11}},
+		{"only newest when disabled", config.RetentionConf{}, names, []int{0, 11}},
+		{"newest kept when expired", config.RetentionConf{KeepAllMinutes: 60, HourlyHours: 6, DailyDays: 5}, names[10:11], []int{0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keep := retainedSnapshots(tc.names, tc.retention, now)
+			want := make(map[string]bool)
+			for _, i := range tc.want {
+				want[tc.names[i]] = true
+			}
+			for _, name := range tc.names {
+				if keep[name] != want[name] {
+					t.Errorf("%s: kept = %v, want %v", name, keep[name], want[name])
+				}
+			}
+			if len(keep) != len(want) {
+				t.Errorf("kept %d snapshots, want %d", len(keep), len(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package beaconImp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"beacon/log"
+
+	_ "modernc.org/sqlite"
+)
+
+// ========== SQLite 后端 ==========
+//
+// 玩家、城池、行军各占一行，每个玩家的战报列表占一行（data 列为实体的 JSON），只有变化的实体会被写入，
+// 已结束的行军和已清空的战报删除对应的行；计数器和游戏时钟作为一行存放在 world 表中，每次保存都会写入。
+// 存档版本同样存放在 world 表中，加载时按 migration.go 中的迁移升级到当前版本。
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id       INTEGER PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	data     TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS cities (
+	id      INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	data    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_cities_user_id ON cities(user_id);
+CREATE TABLE IF NOT EXISTS marches (
+	id   INTEGER PRIMARY KEY,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS reports (
+	user_id INTEGER PRIMARY KEY,
+	data    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS world (
+	key  TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+`
+
+const (
+	worldStateKey         = "state"
+	worldSchemaVersionKey = "schema_version"
+)
+
+// sqliteStorage SQLite 存储后端（纯 Go 实现，无需 cgo）
+type sqliteStorage struct {
+	db       *sql.DB
+	savedSeq uint64 // 数据库中状态包含的最后一条命令序号
+}
+
+// newSQLiteStorage 打开（或创建）数据库并初始化表结构
+func newSQLiteStorage(path string) (*sqliteStorage, error) {
+	dsn := "file:" + path + "?_pragma=journal_mode(WAL)&_pragma=synchronous(FULL)&_pragma=busy_timeout(5000)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	// 单连接，保存在事务内串行执行；synchronous=FULL 保证提交后才清空命令日志
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init sqlite schema: %w", err)
+	}
+	return &sqliteStorage{db: db}, nil
+}
+
+// Load 从数据库重建游戏状态
+func (s *sqliteStorage) Load() (*GameState, error) {
+	var world []byte
+	err := s.db.QueryRow(`SELECT data FROM world WHERE key = ?`, worldStateKey).Scan(&world)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load world: %w", err)
+	}
+
+	raw := newRawSnapshot(world)
+	for _, table := range []struct {
+		query string
+		rows  map[uint]json.RawMessage
+	}{
+		{`SELECT id, data FROM users`, raw.Users},
+		{`SELECT id, data FROM cities`, raw.Cities},
+		{`SELECT id, data FROM marches`, raw.Marches},
+		{`SELECT user_id, data FROM reports`, raw.Reports},
+	} {
+		if err := s.loadRows(table.query, table.rows); err != nil {
+			return nil, err
+		}
+	}
+
+	// 未记录版本的数据库由引入 SQLite 后端时的版本写出
+	version := schemaVersionBuildingsMap
+	var versionData string
+	err = s.db.QueryRow(`SELECT data FROM world WHERE key = ?`, worldSchemaVersionKey).Scan(&versionData)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("load schema version: %w", err)
+	}
+	if err == nil {
+		if version, err = strconv.Atoi(versionData); err != nil {
+			return nil, fmt.Errorf("invalid schema version %q", versionData)
+		}
+	}
+	if err := migrateSnapshot(version, raw); err != nil {
+		return nil, err
+	}
+
+	state, err := decodeState(raw)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Loaded state from sqlite: %d users, %d cities, %d marches",
+		len(state.Users), len(state.Cities), len(state.Marches))
+	s.savedSeq = state.LastCommandSeq
+	return state, nil
+}
+
+// loadRows 读取查询结果的 (id, data) 行到 result
+func (s *sqliteStorage) loadRows(query string, result map[uint]json.RawMessage) error {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return fmt.Errorf("query %q: %w", query, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return err
+		}
+		result[id] = data
+	}
+	return rows.Err()
+}
+
+// NeedFull 按行原地更新，不需要全量合并
+func (s *sqliteStorage) NeedFull() bool {
+	return false
+}
+
+// Save 在一个事务内重写全部实体和全局数据
+func (s *sqliteStorage) Save(snap *StateSnapshot) error {
+	return s.saveTx(snap, func(tx *sql.Tx) error {
+		for _, table := range []string{"users", "cities", "marches", "reports"} {
+			if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+				return err
+			}
+		}
+		if err := writeRows(tx, snap); err != nil {
+			return err
+		}
+		log.Infof("State saved to sqlite: %d users, %d cities", len(snap.Users), len(snap.Cities))
+		return nil
+	})
+}
+
+// SaveChanges 在一个事务内写入变化的实体和全局数据
+func (s *sqliteStorage) SaveChanges(snap *StateSnapshot) error {
+	return s.saveTx(snap, func(tx *sql.Tx) error {
+		if err := writeRows(tx, snap); err != nil {
+			return err
+		}
+		log.Debugf("State changes saved to sqlite: %d users, %d cities, %d marches, %d report lists",
+			len(snap.Users), len(snap.Cities), len(snap.Marches), len(snap.Reports))
+		return nil
+	})
+}
+
+// FallbackSeq 事务提交后数据库不会回退到更早的状态，只需保留最近一次保存之后的命令
+func (s *sqliteStorage) FallbackSeq() uint64 {
+	return s.savedSeq
+}
+
+// saveTx 在事务内写入快照，提交成功后记录快照包含的命令序号
+func (s *sqliteStorage) saveTx(snap *StateSnapshot, fn func(tx *sql.Tx) error) error {
+	if err := s.withTx(fn); err != nil {
+		return err
+	}
+	s.savedSeq = snap.LastCommandSeq
+	return nil
+}
+
+// Close 关闭数据库
+func (s *sqliteStorage) Close() error {
+	return s.db.Close()
+}
+
+// withTx 在事务内执行，出错时回滚
+func (s *sqliteStorage) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// writeRows 写入快照中的实体、全局数据和存档版本
+func writeRows(tx *sql.Tx, snap *StateSnapshot) error {
+	for id, u := range snap.Users {
+		_, err := tx.Exec(`INSERT INTO users (id, username, data) VALUES (?, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET username = excluded.username, data = excluded.data`,
+			id, u.Username, string(u.Data))
+		if err != nil {
+			return fmt.Errorf("save user %d: %w", id, err)
+		}
+	}
+	for id, c := range snap.Cities {
+		_, err := tx.Exec(`INSERT INTO cities (id, user_id, data) VALUES (?, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET user_id = excluded.user_id, data = excluded.data`,
+			id, c.UserID, string(c.Data))
+		if err != nil {
+			return fmt.Errorf("save city %d: %w", id, err)
+		}
+	}
+	for id, data := range snap.Marches {
+		if err := writeOrDeleteRow(tx, "marches", "id", id, data); err != nil {
+			return fmt.Errorf("save march %d: %w", id, err)
+		}
+	}
+	for userID, data := range snap.Reports {
+		if err := writeOrDeleteRow(tx, "reports", "user_id", userID, data); err != nil {
+			return fmt.Errorf("save reports of user %d: %w", userID, err)
+		}
+	}
+	for key, data := range map[string]string{
+		worldStateKey:         string(snap.World),
+		worldSchemaVersionKey: strconv.Itoa(currentSchemaVersion),
+	} {
+		_, err := tx.Exec(`INSERT INTO world (key, data) VALUES (?, ?)
+			ON CONFLICT(key) DO UPDATE SET data = excluded.data`, key, data)
+		if err != nil {
+			return fmt.Errorf("save world %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// writeOrDeleteRow 写入 (key, data) 行，data 为 nil 时删除该行
+func writeOrDeleteRow(tx *sql.Tx, table, keyColumn string, key uint, data json.RawMessage) error {
+	if data == nil {
+		_, err := tx.Exec(`DELETE FROM `+table+` WHERE `+keyColumn+` = ?`, key)
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO `+table+` (`+keyColumn+`, data) VALUES (?, ?)
+		ON CONFLICT(`+keyColumn+`) DO UPDATE SET data = excluded.data`, key, string(data))
+	return err
+}
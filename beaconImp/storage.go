@@ -0,0 +1,244 @@
+package beaconImp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"beacon/config"
+)
+
+const dataDir = "./data"
+
+// ========== Storage - 持久化后端 ==========
+//
+// Beacon 通过 Storage 接口加载和保存游戏状态，后端由 conf/server.toml 的 [storage] 选择：
+//   - json：全量 + 增量 JSON 快照文件（snapshot.go）
+//   - sqlite：内嵌 SQLite 数据库，玩家和城池按行存储（sqlite_storage.go）
+//
+// 保存分两步：持有读锁把变化的实体编码为 StateSnapshot（captureSnapshot），释放锁后交给后端写入，
+// 避免磁盘 I/O 阻塞 tick 和玩家操作。
+// 后端加载时先按存档版本执行结构迁移（migration.go），再解码为游戏状态。
+// 加载后的兼容处理（补齐建筑、重建地图等）和命令日志重放与后端无关，由 LoadLatestSnapshot 统一完成。
+
+// 持久化的实体：玩家（按玩家ID）、城池（按城池ID）、行军（按行军ID）、战报（按玩家ID，整个列表），
+// 以及只含计数器和游戏时钟的全局数据。
+
+// Storage 游戏状态持久化后端（只由启动流程和快照任务调用，无需并发安全）
+type Storage interface {
+	// Load 加载已保存的游戏状态，没有数据时返回 nil
+	Load() (*GameState, error)
+	// NeedFull 下一次保存是否需要包含全部实体（如 JSON 后端的全量合并）
+	NeedFull() bool
+	// Save 保存完整游戏状态（snap.Full 为 true）
+	Save(snap *StateSnapshot) error
+	// SaveChanges 保存变化的实体（已删除的行军和已清空的战报需删除），以及全局数据
+	SaveChanges(snap *StateSnapshot) error
+	// FallbackSeq 命令日志只能清理不超过该序号的命令：最新数据损坏时加载可回退到的最旧状态所包含的最后一条命令
+	FallbackSeq() uint64
+	// Close 关闭后端
+	Close() error
+}
+
+// StateSnapshot 从游戏状态中提取的待保存数据（已编码，与内存状态无共享）
+type StateSnapshot struct {
+	Full           bool                     // 是否包含全部实体
+	LastCommandSeq uint64                   // 快照包含的最后一条命令序号
+	World          json.RawMessage          // 全局数据：计数器、游戏时钟
+	Users          map[uint]*userRecord     // 玩家ID -> 编码后的玩家
+	Cities         map[uint]*cityRecord     // 城池ID -> 编码后的城池
+	Marches        map[uint]json.RawMessage // 行军ID -> 编码后的行军（nil 表示行军已结束）
+	Reports        map[uint]json.RawMessage // 玩家ID -> 编码后的战报列表（nil 表示没有战报）
+	Changes        *ChangeSet               // 本次取出的变化集合（保存失败时恢复）
+}
+
+// userRecord 编码后的玩家
+type userRecord struct {
+	Username string
+	Data     json.RawMessage
+}
+
+// cityRecord 编码后的城池
+type cityRecord struct {
+	UserID uint
+	Data   json.RawMessage
+}
+
+// rawSnapshot 编码后的完整存档（加载、迁移和解码时使用）
+type rawSnapshot struct {
+	World   json.RawMessage
+	Users   map[uint]json.RawMessage
+	Cities  map[uint]json.RawMessage
+	Marches map[uint]json.RawMessage // 行军ID -> 行军
+	Reports map[uint]json.RawMessage // 玩家ID -> 战报列表
+}
+
+// newRawSnapshot 创建空的存档
+func newRawSnapshot(world json.RawMessage) *rawSnapshot {
+	return &rawSnapshot{
+		World:   world,
+		Users:   make(map[uint]json.RawMessage),
+		Cities:  make(map[uint]json.RawMessage),
+		Marches: make(map[uint]json.RawMessage),
+		Reports: make(map[uint]json.RawMessage),
+	}
+}
+
+// newStorage 根据服务器配置创建存储后端
+func newStorage() (Storage, error) {
+	conf := config.ServerConfig.Storage
+	switch conf.Backend {
+	case config.StorageBackendJSON:
+		return newJSONSnapshotStorage(conf)
+	case config.StorageBackendSQLite:
+		return newSQLiteStorage(conf.SQLitePath)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", conf.Backend)
+	}
+}
+
+// captureSnapshot 编码全局数据和变化的实体（full 为 true 时编码全部实体）
+// 注意：调用者需持有读锁
+func (B *Beacon) captureSnapshot(full bool) (*StateSnapshot, error) {
+	changes := B.state.takeDirty()
+	snap, err := encodeSnapshot(B.state, changes, full)
+	if err != nil {
+		B.state.restoreDirty(changes)
+		return nil, err
+	}
+	return snap, nil
+}
+
+// encodeSnapshot 编码快照数据
+func encodeSnapshot(state *GameState, changes *ChangeSet, full bool) (*StateSnapshot, error) {
+	world := *state
+	world.Users = nil
+	world.Cities = nil
+	world.Marches = nil
+	world.Reports = nil
+	worldData, err := json.Marshal(&world)
+	if err != nil {
+		return nil, fmt.Errorf("marshal world: %w", err)
+	}
+
+	snap := &StateSnapshot{
+		Full:           full,
+		LastCommandSeq: state.LastCommandSeq,
+		World:          worldData,
+		Users:          make(map[uint]*userRecord),
+		Cities:         make(map[uint]*cityRecord),
+		Marches:        make(map[uint]json.RawMessage),
+		Reports:        make(map[uint]json.RawMessage),
+		Changes:        changes,
+	}
+
+	changedUsers := make(map[uint]bool, len(changes.Users))
+	for _, id := range changes.Users {
+		changedUsers[id] = true
+	}
+	for _, u := range state.Users {
+		if !full && !changedUsers[u.ID] {
+			continue
+		}
+		data, err := json.Marshal(u)
+		if err != nil {
+			return nil, fmt.Errorf("marshal user %d: %w", u.ID, err)
+		}
+		snap.Users[u.ID] = &userRecord{Username: u.Username, Data: data}
+	}
+
+	cityIDs, marchIDs, reportUserIDs := changes.Cities, changes.Marches, changes.Reports
+	if full {
+		cityIDs, marchIDs, reportUserIDs = sortedIDs(state.Cities), sortedIDs(state.Marches), sortedIDs(state.Reports)
+	}
+	for _, id := range cityIDs {
+		c, ok := state.Cities[id]
+		if !ok {
+			continue
+		}
+		data, err := json.Marshal(c)
+		if err != nil {
+			return nil, fmt.Errorf("marshal city %d: %w", c.ID, err)
+		}
+		snap.Cities[c.ID] = &cityRecord{UserID: c.UserID, Data: data}
+	}
+	for _, id := range marchIDs {
+		m, ok := state.Marches[id]
+		if !ok {
+			snap.Marches[id] = nil
+			continue
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return nil, fmt.Errorf("marshal march %d: %w", id, err)
+		}
+		snap.Marches[id] = data
+	}
+	for _, userID := range reportUserIDs {
+		reports := state.Reports[userID]
+		if len(reports) == 0 {
+			snap.Reports[userID] = nil
+			continue
+		}
+		data, err := json.Marshal(reports)
+		if err != nil {
+			return nil, fmt.Errorf("marshal reports of user %d: %w", userID, err)
+		}
+		snap.Reports[userID] = data
+	}
+	return snap, nil
+}
+
+// decodeState 由编码后的存档重建游戏状态（值为 null 的行军和战报表示已删除）
+func decodeState(raw *rawSnapshot) (*GameState, error) {
+	state := NewGameState()
+	if err := json.Unmarshal(raw.World, state); err != nil {
+		return nil, fmt.Errorf("unmarshal world: %w", err)
+	}
+	state.Users = make(map[string]*User, len(raw.Users))
+	state.Cities = make(map[uint]*City, len(raw.Cities))
+	state.Marches = make(map[uint]*March, len(raw.Marches))
+	state.Reports = make(map[uint][]*Report, len(raw.Reports))
+
+	for id, data := range raw.Users {
+		user := &User{}
+		if err := json.Unmarshal(data, user); err != nil {
+			return nil, fmt.Errorf("unmarshal user %d: %w", id, err)
+		}
+		state.Users[user.Username] = user
+	}
+	for id, data := range raw.Cities {
+		city := &City{}
+		if err := json.Unmarshal(data, city); err != nil {
+			return nil, fmt.Errorf("unmarshal city %d: %w", id, err)
+		}
+		state.Cities[city.ID] = city
+	}
+	for id, data := range raw.Marches {
+		if isNullJSON(data) {
+			continue
+		}
+		m := &March{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, fmt.Errorf("unmarshal march %d: %w", id, err)
+		}
+		state.Marches[m.ID] = m
+	}
+	for userID, data := range raw.Reports {
+		if isNullJSON(data) {
+			continue
+		}
+		var reports []*Report
+		if err := json.Unmarshal(data, &reports); err != nil {
+			return nil, fmt.Errorf("unmarshal reports of user %d: %w", userID, err)
+		}
+		if len(reports) > 0 {
+			state.Reports[userID] = reports
+		}
+	}
+	return state, nil
+}
+
+// isNullJSON 是否为空值（已删除的实体）
+func isNullJSON(data json.RawMessage) bool {
+	return len(data) == 0 || string(data) == "null"
+}
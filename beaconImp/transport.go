@@ -0,0 +1,138 @@
+package beaconImp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"beacon/config"
+	"beacon/log"
+)
+
+// ========== Transport - 城池间资源运输 ==========
+//
+// 运输车队只能由运输职能（role = "transport"）的兵种组成，载货量受部队总负重限制。
+// 到达目标城池后按仓库剩余容量卸货（金币无上限），卸不下的资源随车队返回出发城池。
+
+// GetResourceCapacity 获取城池仓库容量（木/石/铁/粮各自的上限，所有建筑容量之和，目前为仓库；<= 0 表示不限容量）
+func (c *City) GetResourceCapacity() int {
+	capacity := 0
+	for _, b := range c.GetAllBuildings() {
+		if conf := config.GetBuildingLevel(string(b.Type), b.Level); conf != nil {
+			capacity += conf.Capacity
+		}
+	}
+	return capacity
+}
+
+// capResource 按仓库容量截断资源数量（capacity <= 0 时不限容量）
+func capResource(amount, capacity int) int {
+	if capacity <= 0 {
+		return amount
+	}
+	return min(amount, capacity)
+}
+
+// Total 资源总量
+func (r Resources) Total() int {
+	return r.Wood + r.Stone + r.Iron + r.Food + r.Gold
+}
+
+// validateTransport 派出运输车队前的校验
+func (B *Beacon) validateTransport(city *City, toX, toY int, troops []*Troop) error {
+	for _, t := range troops {
+		if !HasTroopRole(t.Type, config.TroopRoleTransport) {
+			return errors.New("运输只能使用运输类部队")
+		}
+	}
+	target, err := B.state.GetCity(B.state.WorldMap().CityAt(toX, toY))
+	if err != nil || target.UserID != city.UserID {
+		return errors.New("只能向己方城池运输")
+	}
+	return nil
+}
+
+// SendTransport 派出运输车队，从城池装载资源
+// 注意：调用者需持有写锁
+func (B *Beacon) SendTransport(city *City, toX, toY int, troops []*Troop, cargo Resources) (*March, error) {
+	if cargo.Wood < 0 || cargo.Stone < 0 || cargo.Iron < 0 || cargo.Food < 0 || cargo.Gold < 0 {
+		return nil, errors.New("无效的运输数量")
+	}
+	if cargo.IsEmpty() {
+		return nil, errors.New("未选择运输资源")
+	}
+	if cargo.Total() > B.calcUserCarryCapacity(city.UserID, troops) {
+		return nil, errors.New("超出运输车队负重")
+	}
+	if city.Wood < cargo.Wood || city.Stone < cargo.Stone || city.Iron < cargo.Iron ||
+		city.Food < cargo.Food || city.Gold < cargo.Gold {
+		return nil, errors.New("资源不足")
+	}
+
+	m, err := B.SendMarch(city, MarchTransport, toX, toY, troops)
+	if err != nil {
+		return nil, err
+	}
+
+	city.Wood -= cargo.Wood
+	city.Stone -= cargo.Stone
+	city.Iron -= cargo.Iron
+	city.Food -= cargo.Food
+	city.Gold -= cargo.Gold
+	m.Cargo = cargo
+
+	log.Infof("Transport loaded: march=%d, cargo=%+v", m.ID, cargo)
+	return m, nil
+}
+
+// resolveTransport 运输车队到达：向目标城池卸货
+func (B *Beacon) resolveTransport(m *March) {
+	target, err := B.state.GetCity(B.state.WorldMap().CityAt(m.ToX, m.ToY))
+	if err != nil || target.UserID != m.UserID {
+		// 目标城池已不属于自己，原样返回
+		B.returnMarch(m)
+		return
+	}
+
+	unloaded := unloadCargo(target, &m.Cargo)
+	log.Infof("Transport unloaded: march=%d, city=%d, unloaded=%+v, remaining=%+v",
+		m.ID, target.ID, unloaded, m.Cargo)
+
+	message := fmt.Sprintf("向「%s」运送 木%d 石%d 铁%d 粮%d 金%d",
+		target.Name, unloaded.Wood, unloaded.Stone, unloaded.Iron, unloaded.Food, unloaded.Gold)
+	if !m.Cargo.IsEmpty() {
+		message += "，仓库已满，剩余资源随车队返回"
+	}
+	B.state.AddReport(&Report{
+		UserID:    m.UserID,
+		Type:      ReportTransport,
+		Title:     "运输到达 " + target.Name,
+		CreatedAt: time.Now().Unix(),
+		Loot:      unloaded,
+		Message:   message,
+	})
+
+	B.returnMarch(m)
+}
+
+// unloadCargo 按仓库剩余容量卸货，返回实际卸下的资源，cargo 中保留卸不下的部分
+func unloadCargo(city *City, cargo *Resources) Resources {
+	capacity := city.GetResourceCapacity()
+	unload := func(stock *int, carried *int) int {
+		amount := max(capResource(*stock+*carried, capacity)-*stock, 0)
+		*stock += amount
+		*carried -= amount
+		return amount
+	}
+
+	unloaded := Resources{
+		Wood:  unload(&city.Wood, &cargo.Wood),
+		Stone: unload(&city.Stone, &cargo.Stone),
+		Iron:  unload(&city.Iron, &cargo.Iron),
+		Food:  unload(&city.Food, &cargo.Food),
+		Gold:  cargo.Gold, // 金币无上限
+	}
+	city.Gold += cargo.Gold
+	cargo.Gold = 0
+	return unloaded
+}
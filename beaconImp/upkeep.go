@@ -0,0 +1,169 @@
+package beaconImp
+
+import (
+	"sort"
+
+	"beacon/config"
+	"beacon/log"
+)
+
+// ========== Upkeep - 部队粮食消耗 ==========
+//
+// 每个兵种每小时消耗 FoodConsumption 粮食，由部队所属城池（出发城池）承担，
+// 驻扎在城内、正在行军和驻防在其他城池的部队都需要消耗。
+//
+// 断粮：城池粮食降至0后，未能支付的粮食记为欠粮（City.FoodDeficit）。
+// 欠粮每累计到某兵种的单位小时消耗，就有一名该兵种士兵逃亡，
+// 优先逃亡消耗最高的兵种（城内驻军优先，其次行军部队，最后驻防部队），直到收支恢复平衡。
+// 行军部队全部逃亡后行军解散，携带的资源退回出发城池。
+
+// troopsFoodUpkeep 计算部队每小时粮食消耗
+func troopsFoodUpkeep(troops []*Troop) int {
+	upkeep := 0
+	for _, t := range troops {
+		if troopConf := config.GetTroopConfig(string(t.Type)); troopConf != nil {
+			upkeep += t.Quantity * troopConf.FoodConsumption
+		}
+	}
+	return upkeep
+}
+
+// calcFoodUpkeepByCity 计算所有城池每小时粮食消耗（cityID -> 消耗）
+func (B *Beacon) calcFoodUpkeepByCity() map[uint]int {
+	upkeep := make(map[uint]int, len(B.state.Cities))
+	for _, city := range B.state.Cities {
+		upkeep[city.ID] += troopsFoodUpkeep(city.Troops)
+		for _, g := range city.Garrisons {
+			upkeep[g.HomeCityID] += troopsFoodUpkeep(g.Troops)
+		}
+	}
+	for _, m := range B.state.Marches {
+		upkeep[m.OriginCityID] += troopsFoodUpkeep(m.Troops)
+	}
+	return upkeep
+}
+
+// calcCityFoodUpkeep 计算单个城池每小时粮食消耗
+func (B *Beacon) calcCityFoodUpkeep(city *City) int {
+	upkeep := troopsFoodUpkeep(city.Troops)
+	for _, m := range B.state.Marches {
+		if m.OriginCityID == city.ID {
+			upkeep += troopsFoodUpkeep(m.Troops)
+		}
+	}
+	for _, g := range B.state.ListGarrisonsAbroad(city.ID) {
+		upkeep += troopsFoodUpkeep(g.Troops)
+	}
+	return upkeep
+}
+
+// calcCityFoodProduction 计算城池每小时粮食产量（计入科技加成）
+func (B *Beacon) calcCityFoodProduction(city *City) int {
+	return B.CalcCityProduction(city).Food
+}
+
+// starveCity 城池断粮：按欠粮让士兵逃亡
+func (B *Beacon) starveCity(city *City) {
+	for city.FoodDeficit > 0 {
+		troop, consumption, cleanup := B.pickDeserter(city)
+		if troop == nil {
+			// 已无可逃亡的部队，欠粮清零
+			city.FoodDeficit = 0
+			return
+		}
+		if city.FoodDeficit < consumption {
+			return
+		}
+
+		troop.Quantity--
+		city.FoodDeficit -= consumption
+		B.state.MarkCityDirty(city.ID)
+		log.Infof("Troop deserted due to starvation: city=%d, type=%s", city.ID, troop.Type)
+
+		cleanup()
+	}
+}
+
+// pickDeserter 选择逃亡的士兵：优先城内驻军，其次行军部队，最后驻防部队，同一范围内选消耗最高的兵种
+// 返回部队、该兵种单位消耗，以及逃亡后清理所属部队列表的函数
+func (B *Beacon) pickDeserter(city *City) (*Troop, int, func()) {
+	if troop, consumption := mostExpensiveTroop(city.Troops); troop != nil {
+		return troop, consumption, func() { city.Troops = compactTroops(city.Troops) }
+	}
+
+	// 按ID遍历行军，保证结果确定
+	marchIDs := make([]uint, 0)
+	for _, m := range B.state.Marches {
+		if m.OriginCityID == city.ID {
+			marchIDs = append(marchIDs, m.ID)
+		}
+	}
+	sort.Slice(marchIDs, func(i, j int) bool { return marchIDs[i] < marchIDs[j] })
+
+	var best *Troop
+	var bestConsumption int
+	var bestMarch *March
+	for _, id := range marchIDs {
+		m := B.state.Marches[id]
+		if troop, consumption := mostExpensiveTroop(m.Troops); troop != nil && consumption > bestConsumption {
+			best, bestConsumption, bestMarch = troop, consumption, m
+		}
+	}
+	if best != nil {
+		return best, bestConsumption, func() {
+			B.state.MarkMarchDirty(bestMarch.ID)
+			bestMarch.Troops = compactTroops(bestMarch.Troops)
+			if len(bestMarch.Troops) == 0 {
+				delete(B.state.Marches, bestMarch.ID)
+				// 携带的资源退回出发城池（超出仓库容量的部分作废）
+				if !bestMarch.Cargo.IsEmpty() {
+					city.AddResources(bestMarch.Cargo)
+					B.applyCityResourceCap(city)
+				}
+			}
+		}
+	}
+
+	// 按驻防城池ID遍历驻防部队
+	garrisons := B.state.ListGarrisonsAbroad(city.ID)
+	stationIDs := make([]uint, 0, len(garrisons))
+	for id := range garrisons {
+		stationIDs = append(stationIDs, id)
+	}
+	sort.Slice(stationIDs, func(i, j int) bool { return stationIDs[i] < stationIDs[j] })
+
+	var bestStation *City
+	for _, id := range stationIDs {
+		if troop, consumption := mostExpensiveTroop(garrisons[id].Troops); troop != nil && consumption > bestConsumption {
+			best, bestConsumption = troop, consumption
+			bestStation = B.state.Cities[id]
+		}
+	}
+	if best != nil {
+		return best, bestConsumption, func() {
+			bestStation.compactGarrisons()
+			B.state.MarkCityDirty(bestStation.ID)
+		}
+	}
+	return nil, 0, nil
+}
+
+// mostExpensiveTroop 找出粮食消耗最高的兵种（消耗相同时按类型名排序）
+func mostExpensiveTroop(troops []*Troop) (*Troop, int) {
+	var best *Troop
+	bestConsumption := 0
+	for _, t := range troops {
+		if t.Quantity <= 0 {
+			continue
+		}
+		troopConf := config.GetTroopConfig(string(t.Type))
+		if troopConf == nil || troopConf.FoodConsumption <= 0 {
+			continue
+		}
+		if troopConf.FoodConsumption > bestConsumption ||
+			(troopConf.FoodConsumption == bestConsumption && t.Type < best.Type) {
+			best, bestConsumption = t, troopConf.FoodConsumption
+		}
+	}
+	return best, bestConsumption
+}
@@ -0,0 +1,227 @@
+package beaconImp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"beacon/config"
+	"beacon/log"
+)
+
+const (
+	walPath          = "./data/wal.log"
+	walDiscardedPath = "./data/wal.log.discarded" // 因序号缺口被丢弃的日志
+)
+
+// ========== WAL - 命令预写日志 ==========
+//
+// 每条执行成功的命令以一行 JSON 追加到日志并 fsync，快照保存成功后删除回退快照（见 Storage.FallbackSeq）已包含的命令。
+// 启动时先加载最新快照，再按序号重放日志中比快照更新的命令（Seq > GameState.LastCommandSeq）。
+// 加载的快照比日志旧时（如最新和上一个快照都损坏，回退到更早的快照），中间的命令已随快照保存从日志中清理，
+// 之后的命令不能重放到旧状态上：默认拒绝启动；配置 storage.discard_wal_on_gap 后丢弃这些命令
+// （原日志另存为 wal.log.discarded）。
+// 命令记录执行时的游戏时钟（GameState.Elapsed），重放前先按与实际运行相同的固定步长（tickStep）
+// 把游戏逻辑推进到该时刻，使资源产出、队列和行军与命令执行时完全一致。
+
+// CommandRecord 日志中的一条命令
+type CommandRecord struct {
+	Seq     uint64          `json:"seq"`
+	Type    CommandType     `json:"type"`
+	UserID  uint            `json:"user_id"`
+	Elapsed float64         `json:"elapsed"` // 执行时的游戏时钟（秒）
+	Time    int64           `json:"time"`    // 执行时的Unix时间（仅用于战报过期清理）
+	Payload json.RawMessage `json:"payload"`
+}
+
+// openWAL 打开日志文件用于追加，截断到 validSize（丢弃崩溃时写了一半的记录）
+func (B *Beacon) openWAL(validSize int64) error {
+	f, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open wal: %w", err)
+	}
+	if err := f.Truncate(validSize); err != nil {
+		f.Close()
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	B.wal = f
+	return nil
+}
+
+// appendCommand 追加一条命令到日志并刷盘
+// 注意：调用者需持有写锁
+func (B *Beacon) appendCommand(userID uint, cmd gameCommand) error {
+	B.state.LastCommandSeq++
+	if B.wal == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+	line, err := json.Marshal(&CommandRecord{
+		Seq:     B.state.LastCommandSeq,
+		Type:    cmd.commandType(),
+		UserID:  userID,
+		Elapsed: B.state.Elapsed,
+		Time:    time.Now().Unix(),
+		Payload: payload,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+
+	if _, err := B.wal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write wal: %w", err)
+	}
+	return B.wal.Sync()
+}
+
+// compactWAL 删除快照已包含的命令（Seq <= savedSeq），快照保存成功后调用
+// 快照在锁外写入，期间执行的新命令需要保留
+// 注意：调用者需持有写锁
+func (B *Beacon) compactWAL(savedSeq uint64) error {
+	if B.wal == nil {
+		return nil
+	}
+
+	// 快照之后没有新命令，直接清空
+	if B.state.LastCommandSeq <= savedSeq {
+		if err := B.wal.Truncate(0); err != nil {
+			return err
+		}
+		return B.wal.Sync()
+	}
+
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		return fmt.Errorf("read wal: %w", err)
+	}
+	var kept []byte
+	for _, line := range bytes.SplitAfter(data, []byte{'\n'}) {
+		var record CommandRecord
+		if json.Unmarshal(line, &record) == nil && record.Seq > savedSeq {
+			kept = append(kept, line...)
+		}
+	}
+
+	// 写入临时文件后原子替换
+	tmpPath := walPath + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create tmp wal: %w", err)
+	}
+	if _, err := tmp.Write(kept); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write tmp wal: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync tmp wal: %w", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmpPath, walPath); err != nil {
+		return fmt.Errorf("replace wal: %w", err)
+	}
+
+	B.wal.Close()
+	return B.openWAL(int64(len(kept)))
+}
+
+// replayWAL 重放日志中比当前状态更新的命令，返回有效日志的长度
+// 遇到损坏的记录（崩溃时写了一半）即停止，之后的内容会被丢弃；命令序号有缺口时见 handleWALGap
+func (B *Beacon) replayWAL() (int64, error) {
+	f, err := os.Open(walPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("open wal: %w", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var validSize int64
+	replayed := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Warnf("Discarding incomplete wal record at offset %d", validSize)
+			}
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read wal: %w", err)
+		}
+
+		var record CommandRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			log.Warnf("Discarding corrupt wal record at offset %d: %v", validSize, err)
+			break
+		}
+
+		if record.Seq <= B.state.LastCommandSeq {
+			validSize += int64(len(line))
+			continue
+		}
+		if record.Seq > B.state.LastCommandSeq+1 {
+			if err := B.handleWALGap(record.Seq); err != nil {
+				return 0, err
+			}
+			break
+		}
+		validSize += int64(len(line))
+		B.replayCommand(&record)
+		replayed++
+	}
+
+	if replayed > 0 {
+		log.Infof("Replayed %d commands from wal, last seq=%d", replayed, B.state.LastCommandSeq)
+	}
+	return validSize, nil
+}
+
+// handleWALGap 日志从 seq 开始的命令缺少之前的命令（加载的快照比日志旧），这些命令不能重放：
+// 未配置 discard_wal_on_gap 时返回错误拒绝启动，否则另存原日志后从 seq 处截断
+func (B *Beacon) handleWALGap(seq uint64) error {
+	missing := fmt.Sprintf("wal commands %d..%d are missing", B.state.LastCommandSeq+1, seq-1)
+	if !config.ServerConfig.Storage.DiscardWALOnGap {
+		return fmt.Errorf("%s, refusing to replay seq=%d onto an older snapshot "+
+			"(restore the matching snapshot, or set storage.discard_wal_on_gap = true to discard commands from seq=%d)",
+			missing, seq, seq)
+	}
+
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		return fmt.Errorf("read wal: %w", err)
+	}
+	if err := os.WriteFile(walDiscardedPath, data, 0644); err != nil {
+		return fmt.Errorf("save discarded wal: %w", err)
+	}
+	log.Warnf("Discarding wal commands from seq=%d, %s (original wal saved to %s)", seq, missing, walDiscardedPath)
+	return nil
+}
+
+// replayCommand 推进游戏时钟到命令执行时刻后重新执行命令
+func (B *Beacon) replayCommand(record *CommandRecord) {
+	now := time.Unix(record.Time, 0)
+	for record.Elapsed-B.state.Elapsed >= tickStep/2 {
+		B.advance(tickStep, now)
+	}
+
+	B.state.LastCommandSeq = record.Seq
+	cmd, err := decodeCommand(record.Type, record.Payload)
+	if err != nil {
+		log.Errorf("Skipping wal record seq=%d: %v", record.Seq, err)
+		return
+	}
+	if _, err := cmd.apply(B, record.UserID); err != nil {
+		log.Warnf("Replayed command failed: seq=%d, type=%s, err=%v", record.Seq, record.Type, err)
+	}
+}
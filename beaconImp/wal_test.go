@@ -0,0 +1,149 @@
+package beaconImp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"beacon/config"
+)
+
+// TestMain 在仓库根目录加载配置（配置文件路径相对于工作目录）
+func TestMain(m *testing.M) {
+	if err := os.Chdir(".."); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := config.LoadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "load config:", err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// testStorageConf 测试用的 JSON 存储配置
+var testStorageConf = config.StorageConf{
+	Backend:      config.StorageBackendJSON,
+	CompactEvery: 3,
+	Compression:  config.CompressionNone,
+	Retention:    config.RetentionConf{KeepAllMinutes: 60},
+}
+
+// newTestBeacon 在临时目录中创建空状态的服务器（打开命令日志和 JSON 存储）
+func newTestBeacon(t *testing.T) *Beacon {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	return openTestBeacon(t)
+}
+
+// openTestBeacon 在当前目录中按启动流程加载快照和命令日志
+func openTestBeacon(t *testing.T) *Beacon {
+	t.Helper()
+	storage, err := newJSONSnapshotStorage(testStorageConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	B := &Beacon{storage: storage}
+	if err := B.LoadLatestSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { B.wal.Close() })
+	return B
+}
+
+// saveTestSnapshot 与 saveSnapshotTask 相同：保存快照后清理命令日志
+func saveTestSnapshot(t *testing.T, B *Beacon) {
+	t.Helper()
+	snap, err := B.captureSnapshot(B.storage.NeedFull())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := B.SaveSnapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	if err := B.compactWAL(B.storage.FallbackSeq()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mustRun 执行命令，失败时终止测试
+func mustRun(t *testing.T, B *Beacon, userID uint, cmd gameCommand) {
+	t.Helper()
+	if _, err := B.runCommand(userID, cmd); err != nil {
+		t.Fatalf("%s: %v", cmd.commandType(), err)
+	}
+}
+
+// encodeTestState 编码完整的游戏状态（比较两个状态是否一致）
+func encodeTestState(t *testing.T, gs *GameState) string {
+	t.Helper()
+	snap, err := encodeSnapshot(gs, &ChangeSet{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap.Changes = nil
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReplayMatchesLiveState(t *testing.T) {
+	B := newTestBeacon(t)
+	now := time.Unix(1700000000, 0)
+
+	mustRun(t, B, 0, &RegisterCmd{Username: "a", HashedPassword: "x"})
+	mustRun(t, B, 0, &RegisterCmd{Username: "b", HashedPassword: "x"})
+	user, err := B.state.GetUserByUsername("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	city := B.state.ListCitiesByUser(user.ID)[0]
+	targetX, targetY := city.PosX+3, city.PosY
+	if B.state.WorldMap().CityAt(targetX, targetY) != 0 {
+		t.Fatalf("march target (%d,%d) is not empty", targetX, targetY)
+	}
+
+	// 实际运行的 tick 间隔不固定，命令穿插在 tick 之间执行
+	deltas := []float64{0.37, 1.9, 0.8, 1.13, 0.999}
+	marched := false
+	for i := 0; i < 600; i++ {
+		B.advanceWallTime(deltas[i%len(deltas)], now)
+
+		switch i {
+		case 3:
+			mustRun(t, B, user.ID, &UpgradeBuildingCmd{CityID: city.ID, BuildingType: "lumberyard"})
+			mustRun(t, B, user.ID, &UpgradeBuildingCmd{CityID: city.ID, BuildingType: "farm"})
+		case 10:
+			mustRun(t, B, user.ID, &RecruitCmd{CityID: city.ID, TroopType: "spear_shield", Quantity: 3})
+		case 50:
+			saveTestSnapshot(t, B)
+		case 60:
+			mustRun(t, B, user.ID, &RecruitCmd{CityID: city.ID, TroopType: "spear_shield", Quantity: 2})
+		case 120:
+			saveTestSnapshot(t, B)
+		}
+		// 依赖招募完成的行军：重放时招募必须在同一时刻完成
+		if troop := city.GetTroop("spear_shield"); !marched && troop != nil && troop.Quantity >= 2 {
+			mustRun(t, B, user.ID, &SendMarchCmd{
+				CityID: city.ID, Type: MarchAttack, TargetX: targetX, TargetY: targetY,
+				Troops: []*Troop{{Type: "spear_shield", Quantity: 2}},
+			})
+			marched = true
+		}
+	}
+	if !marched {
+		t.Fatal("recruits never finished, march was not sent")
+	}
+	mustRun(t, B, user.ID, &UpgradeBuildingCmd{CityID: city.ID, BuildingType: "quarry"})
+	want := encodeTestState(t, B.state)
+	B.wal.Close()
+
+	restarted := openTestBeacon(t)
+	if got := encodeTestState(t, restarted.state); got != want {
+		t.Fatalf("replayed state differs from live state\nlive:   %s\nreplay: %s", want, got)
+	}
+}
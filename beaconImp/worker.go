@@ -1,7 +1,6 @@
 package beaconImp
 
 import (
-	"beacon/config"
 	"beacon/log"
 	"time"
 )
@@ -15,12 +14,16 @@ func (B *Beacon) Start() {
 	log.Info("Beacon started on port 8000")
 }
 
+// tickStep 游戏逻辑每步推进的游戏时间（秒）
+// 实际运行和命令日志重放都按固定步长推进，队列完成、行军到达和资源累积的舍入在两者之间完全一致
+const tickStep = 1.0
+
 // StartWorker 启动后台工作线程（动态tick间隔 + 每10秒快照）
 func (B *Beacon) StartWorker() {
 	// 初始化上次tick时间
 	B.lastTickTime = time.Now()
 
-	// 游戏逻辑 tick（每秒，但会根据实际时间差计算）
+	// 游戏逻辑 tick（每秒，按实际经过的时间推进固定步数）
 	gameTicker := time.NewTicker(1 * time.Second)
 	go func() {
 		for range gameTicker.C {
@@ -39,13 +42,30 @@ func (B *Beacon) StartWorker() {
 	log.Info("Background worker started: game tick every 1s, snapshot every 10s")
 }
 
-// saveSnapshotTask 定期保存快照（持有读锁）
+// saveSnapshotTask 定期保存快照：持有读锁提取变化的数据，释放锁后写入存储，
+// 成功后持有写锁清理回退快照已包含的命令日志
 func (B *Beacon) saveSnapshotTask() {
 	B.stateLock.RLock()
-	defer B.stateLock.RUnlock()
+	snap, err := B.captureSnapshot(B.storage.NeedFull())
+	B.stateLock.RUnlock()
+	if err != nil {
+		log.Errorf("Failed to capture snapshot: %v", err)
+		return
+	}
+
+	saveErr := B.SaveSnapshot(snap)
 
-	if err := B.SaveSnapshot(); err != nil {
-		log.Errorf("Failed to save snapshot: %v", err)
+	B.stateLock.Lock()
+	defer B.stateLock.Unlock()
+
+	if saveErr != nil {
+		log.Errorf("Failed to save snapshot: %v", saveErr)
+		// 取出的变化尚未写入，下次保存时重新写入
+		B.state.restoreDirty(snap.Changes)
+		return
+	}
+	if err := B.compactWAL(B.storage.FallbackSeq()); err != nil {
+		log.Errorf("Failed to compact wal: %v", err)
 	}
 }
 
@@ -59,10 +79,32 @@ func (B *Beacon) tick() {
 	deltaSeconds := now.Sub(B.lastTickTime).Seconds()
 	B.lastTickTime = now
 
-	// 遍历所有城池，更新状态
-	for _, city := range B.state.Cities {
-		// 1. 更新资源产出
-		B.updateCityResources(city, deltaSeconds)
+	B.advanceWallTime(deltaSeconds, now)
+}
+
+// advanceWallTime 累积实际经过的时间，按固定步长推进游戏逻辑（不足一步的部分留到下次）
+// 注意：调用者需持有写锁
+func (B *Beacon) advanceWallTime(deltaSeconds float64, now time.Time) {
+	B.pendingTime += deltaSeconds
+	for B.pendingTime >= tickStep {
+		B.advance(tickStep, now)
+		B.pendingTime -= tickStep
+	}
+}
+
+// advance 推进游戏逻辑 deltaSeconds 秒（实际运行和命令日志重放都以 tickStep 调用）
+// 注意：调用者需持有写锁
+func (B *Beacon) advance(deltaSeconds float64, now time.Time) {
+	B.state.Elapsed += deltaSeconds
+
+	// 各城池部队的粮食消耗（含行军中的部队）
+	foodUpkeep := B.calcFoodUpkeepByCity()
+
+	// 按ID顺序遍历所有城池，更新状态（顺序固定，命令重放时结果一致）
+	for _, id := range sortedIDs(B.state.Cities) {
+		city := B.state.Cities[id]
+		// 1. 更新资源产出（扣除部队粮食消耗）
+		B.updateCityResources(city, foodUpkeep[city.ID], deltaSeconds)
 
 		// 2. 处理建筑升级队列（只处理第一个）
 		B.processCityBuildingUpgrade(city, deltaSeconds)
@@ -70,37 +112,43 @@ func (B *Beacon) tick() {
 		// 3. 处理招募队列（只处理第一个）
 		B.processCityRecruit(city, deltaSeconds)
 	}
+
+	// 推进所有玩家的研究队列
+	B.processResearch(deltaSeconds)
+
+	// 推进所有行军
+	B.processMarches(deltaSeconds)
+
+	// 清理过期战报
+	B.state.PruneReports(now)
 }
 
 // updateCityResources 更新城池资源（基于实际时间差，使用浮点累积）
-func (B *Beacon) updateCityResources(city *City, deltaSeconds float64) {
-	// 直接获取4个资源生产建筑
-	lumberyardConf := config.GetBuildingLevel(string(BuildingLumberyard), city.Lumberyard.Level)
-	quarryConf := config.GetBuildingLevel(string(BuildingQuarry), city.Quarry.Level)
-	ironMineConf := config.GetBuildingLevel(string(BuildingIronMine), city.IronMine.Level)
-	farmConf := config.GetBuildingLevel(string(BuildingFarm), city.Farm.Level)
+// foodUpkeep 为部队每小时粮食消耗，粮食净产出可能为负
+func (B *Beacon) updateCityResources(city *City, foodUpkeep int, deltaSeconds float64) {
+	// 资源或小数累积变化时标记城池（快照中的累积值必须与游戏时钟一致，命令日志才能在其上重放）
+	before := cityResourceState(city)
+	defer func() {
+		if cityResourceState(city) != before {
+			B.state.MarkCityDirty(city.ID)
+		}
+	}()
 
-	var woodRate, stoneRate, ironRate, foodRate float64
+	// 汇总各生产建筑的产量（计入科技加成）
+	production := B.CalcCityProduction(city)
 
 	// 计算每秒产量 = 每小时产量 / 3600
-	if lumberyardConf != nil {
-		woodRate = float64(lumberyardConf.ProductionPerHour) / 3600.0
-	}
-	if quarryConf != nil {
-		stoneRate = float64(quarryConf.ProductionPerHour) / 3600.0
-	}
-	if ironMineConf != nil {
-		ironRate = float64(ironMineConf.ProductionPerHour) / 3600.0
-	}
-	if farmConf != nil {
-		foodRate = float64(farmConf.ProductionPerHour) / 3600.0
-	}
+	woodRate := float64(production.Wood) / 3600.0
+	stoneRate := float64(production.Stone) / 3600.0
+	ironRate := float64(production.Iron) / 3600.0
+	foodRate := float64(production.Food-foodUpkeep) / 3600.0
 
 	// 累积资源（浮点数）
 	city.WoodAcc += woodRate * deltaSeconds
 	city.StoneAcc += stoneRate * deltaSeconds
 	city.IronAcc += ironRate * deltaSeconds
 	city.FoodAcc += foodRate * deltaSeconds
+	city.GoldAcc += city.CalcGoldIncome() / 3600.0 * deltaSeconds
 
 	// 转换为整数资源
 	if city.WoodAcc >= 1.0 {
@@ -122,38 +170,44 @@ func (B *Beacon) updateCityResources(city *City, deltaSeconds float64) {
 		toAdd := int(city.FoodAcc)
 		city.Food += toAdd
 		city.FoodAcc -= float64(toAdd)
+		city.FoodDeficit = 0 // 粮食恢复正增长，欠粮清零
+	} else if city.FoodAcc <= -1.0 {
+		toSub := int(-city.FoodAcc)
+		city.FoodAcc += float64(toSub)
+		city.Food -= toSub
+		if city.Food < 0 {
+			// 粮食耗尽，记录欠粮并处理士兵逃亡
+			city.FoodDeficit += -city.Food
+			city.Food = 0
+			B.starveCity(city)
+		}
 	}
 
-	// 检查仓库容量上限
+	if city.GoldAcc >= 1.0 {
+		toAdd := int(city.GoldAcc)
+		city.Gold += toAdd
+		city.GoldAcc -= float64(toAdd)
+	}
+
+	// 检查仓库容量上限（金币无上限）
 	B.applyCityResourceCap(city)
 }
 
-// applyCityResourceCap 应用仓库容量上限
-func (B *Beacon) applyCityResourceCap(city *City) {
-	// 获取仓库建筑
-	warehouse := city.Warehouse
-	if warehouse == nil {
-		return
-	}
-
-	warehouseConf := config.GetBuildingLevel("warehouse", warehouse.Level)
-	if warehouseConf == nil {
-		return
+// cityResourceState 城池的资源、小数累积和欠粮（判断资源更新是否修改了城池）
+func cityResourceState(city *City) [11]float64 {
+	return [...]float64{
+		float64(city.Wood), float64(city.Stone), float64(city.Iron), float64(city.Food), float64(city.Gold),
+		city.WoodAcc, city.StoneAcc, city.IronAcc, city.FoodAcc, city.GoldAcc, float64(city.FoodDeficit),
 	}
+}
 
-	capacity := warehouseConf.Capacity
-	if city.Wood > capacity {
-		city.Wood = capacity
-	}
-	if city.Stone > capacity {
-		city.Stone = capacity
-	}
-	if city.Iron > capacity {
-		city.Iron = capacity
-	}
-	if city.Food > capacity {
-		city.Food = capacity
-	}
+// applyCityResourceCap 应用仓库容量上限
+func (B *Beacon) applyCityResourceCap(city *City) {
+	capacity := city.GetResourceCapacity()
+	city.Wood = capResource(city.Wood, capacity)
+	city.Stone = capResource(city.Stone, capacity)
+	city.Iron = capResource(city.Iron, capacity)
+	city.Food = capResource(city.Food, capacity)
 }
 
 // processCityBuildingUpgrade 处理城池的建筑升级队列（只处理第一个任务）
@@ -167,6 +221,7 @@ func (B *Beacon) processCityBuildingUpgrade(city *City, deltaSeconds float64) {
 
 	// 递减剩余时间
 	queue.RemainingTime -= deltaSeconds
+	B.state.MarkCityDirty(city.ID)
 
 	if queue.RemainingTime <= 0 {
 		// 升级完成
@@ -190,6 +245,7 @@ func (B *Beacon) processCityRecruit(city *City, deltaSeconds float64) {
 
 	// 递减当前单位剩余时间
 	queue.RemainingTime -= deltaSeconds
+	B.state.MarkCityDirty(city.ID)
 
 	if queue.RemainingTime <= 0 {
 		// 完成一个单位
@@ -0,0 +1,189 @@
+package beaconImp
+
+import (
+	"errors"
+	"math"
+)
+
+// ========== World Map - 世界地图 ==========
+
+const (
+	mapWidth   = 201 // 地图宽度（坐标 0 ~ 200）
+	mapHeight  = 201 // 地图高度（坐标 0 ~ 200）
+	mapCenterX = 100 // 地图中心（新城池从中心向外环形生成）
+	mapCenterY = 100
+
+	maxMapViewRadius = 15 // 地图查询最大半径
+	spawnRingGap     = 2  // 出生点间隔（出生点只选偶数坐标，相邻城池之间至少隔一格）
+)
+
+// TerrainType 地形类型
+type TerrainType string
+
+const (
+	TerrainPlain    TerrainType = "plain"
+	TerrainForest   TerrainType = "forest"
+	TerrainMountain TerrainType = "mountain"
+	TerrainLake     TerrainType = "lake"
+)
+
+// Tile 地图格子
+type Tile struct {
+	X       int         `json:"x"`
+	Y       int         `json:"y"`
+	Terrain TerrainType `json:"terrain"`
+	CityID  uint        `json:"city_id,omitempty"` // 0 表示无城池
+}
+
+// WorldMap 世界地图
+// 地形由坐标确定性生成，无需持久化；城池占用由 GameState.Cities 重建
+type WorldMap struct {
+	Width    int
+	Height   int
+	occupied map[[2]int]uint // (x, y) -> cityID
+}
+
+// NewWorldMap 创建空地图
+func NewWorldMap(width, height int) *WorldMap {
+	return &WorldMap{
+		Width:    width,
+		Height:   height,
+		occupied: make(map[[2]int]uint),
+	}
+}
+
+// InBounds 坐标是否在地图范围内
+func (m *WorldMap) InBounds(x, y int) bool {
+	return x >= 0 && x < m.Width && y >= 0 && y < m.Height
+}
+
+// TerrainAt 获取指定坐标的地形（基于坐标哈希，确定性生成）
+func (m *WorldMap) TerrainAt(x, y int) TerrainType {
+	// 中心区域保证为平原，方便新玩家出生
+	if abs(x-mapCenterX) <= 2 && abs(y-mapCenterY) <= 2 {
+		return TerrainPlain
+	}
+
+	h := uint32(x)*73856093 ^ uint32(y)*19349663
+	h ^= h >> 13
+	h *= 0x5bd1e995
+	h ^= h >> 15
+
+	switch v := h % 100; {
+	case v < 8:
+		return TerrainLake
+	case v < 18:
+		return TerrainMountain
+	case v < 40:
+		return TerrainForest
+	default:
+		return TerrainPlain
+	}
+}
+
+// IsBuildable 地形是否可以建城
+func (m *WorldMap) IsBuildable(x, y int) bool {
+	switch m.TerrainAt(x, y) {
+	case TerrainLake, TerrainMountain:
+		return false
+	default:
+		return true
+	}
+}
+
+// CityAt 获取指定坐标的城池ID（0 表示空地）
+func (m *WorldMap) CityAt(x, y int) uint {
+	return m.occupied[[2]int{x, y}]
+}
+
+// IsFree 坐标是否可以放置新城池
+func (m *WorldMap) IsFree(x, y int) bool {
+	return m.InBounds(x, y) && m.IsBuildable(x, y) && m.CityAt(x, y) == 0
+}
+
+// TileAt 获取指定坐标的格子
+func (m *WorldMap) TileAt(x, y int) Tile {
+	return Tile{
+		X:       x,
+		Y:       y,
+		Terrain: m.TerrainAt(x, y),
+		CityID:  m.CityAt(x, y),
+	}
+}
+
+// Place 将城池放置到指定坐标
+func (m *WorldMap) Place(x, y int, cityID uint) error {
+	if !m.InBounds(x, y) {
+		return errors.New("position out of bounds")
+	}
+	if !m.IsBuildable(x, y) {
+		return errors.New("terrain not buildable")
+	}
+	if m.CityAt(x, y) != 0 {
+		return errors.New("position occupied")
+	}
+	m.occupied[[2]int{x, y}] = cityID
+	return nil
+}
+
+// Remove 移除指定坐标的城池
+func (m *WorldMap) Remove(x, y int) {
+	delete(m.occupied, [2]int{x, y})
+}
+
+// FindSpawnPosition 从地图中心向外逐环查找可用出生点
+// 每一环上的格子按顺时针遍历，且只考虑间隔 spawnRingGap 的格子，避免城池紧贴
+func (m *WorldMap) FindSpawnPosition() (int, int, error) {
+	maxRing := max(m.Width, m.Height)
+	for ring := 0; ring <= maxRing; ring += spawnRingGap {
+		for _, p := range ringPositions(mapCenterX, mapCenterY, ring) {
+			x, y := p[0], p[1]
+			if x%spawnRingGap != 0 || y%spawnRingGap != 0 {
+				continue
+			}
+			if m.IsFree(x, y) {
+				return x, y, nil
+			}
+		}
+	}
+	return 0, 0, errors.New("world map is full")
+}
+
+// ringPositions 返回以 (cx, cy) 为中心、切比雪夫距离为 ring 的所有坐标（顺时针）
+func ringPositions(cx, cy, ring int) [][2]int {
+	if ring == 0 {
+		return [][2]int{{cx, cy}}
+	}
+	positions := make([][2]int, 0, ring*8)
+	// 上边：从左到右
+	for x := cx - ring; x < cx+ring; x++ {
+		positions = append(positions, [2]int{x, cy - ring})
+	}
+	// 右边：从上到下
+	for y := cy - ring; y < cy+ring; y++ {
+		positions = append(positions, [2]int{cx + ring, y})
+	}
+	// 下边：从右到左
+	for x := cx + ring; x > cx-ring; x-- {
+		positions = append(positions, [2]int{x, cy + ring})
+	}
+	// 左边：从下到上
+	for y := cy + ring; y > cy-ring; y-- {
+		positions = append(positions, [2]int{cx - ring, y})
+	}
+	return positions
+}
+
+// Distance 两点之间的欧氏距离（格）
+func Distance(x1, y1, x2, y2 int) float64 {
+	dx := float64(x1 - x2)
+	dy := float64(y1 - y2)
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
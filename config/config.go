@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"sort"
 	"sync"
 
 	"github.com/pelletier/go-toml/v2"
@@ -11,7 +13,14 @@ import (
 var (
 	BuildingConfig *BuildingConf
 	TroopConfig    *TroopConf
+	EconomyConfig  *EconomyConf
+	ResearchConfig *ResearchConf
+	ServerConfig   *ServerConf
 	once           sync.Once
+
+	troopIndex    map[string]*TroopAttr // 兵种类型 -> 兵种配置
+	buildingTypes []string              // 按显示顺序排列的建筑类型
+	researchTypes []string              // 按显示顺序排列的科技类型
 )
 
 // BuildingLevelConf 建筑等级配置
@@ -27,36 +36,57 @@ type BuildingLevelConf struct {
 	UpgradeCostIron    int `toml:"upgrade_cost_iron" json:"upgrade_cost_iron"`
 	UpgradeCostFood    int `toml:"upgrade_cost_food" json:"upgrade_cost_food"`
 	UpgradeCostGold    int `toml:"upgrade_cost_gold" json:"upgrade_cost_gold"`
-	PopulationCost     int `toml:"population_cost" json:"population_cost"`
+	PopulationCost     int `toml:"population_cost" json:"population_cost"`         // 该等级建筑占用的人口
+	PopulationCapacity int `toml:"population_capacity" json:"population_capacity"` // 提供的人口上限（农田）
+	CityLimit          int `toml:"city_limit" json:"city_limit"`                   // 玩家城池数量上限（官府）
+
+	Requires map[string]int `toml:"requires" json:"requires,omitempty"` // 升到该等级的前置建筑（建筑类型 -> 最低等级）
+}
+
+// BuildingTypeConf 单个建筑类型的配置
+type BuildingTypeConf struct {
+	Name         string              `toml:"name"`          // 中文名称
+	Order        int                 `toml:"order"`         // 显示顺序（从小到大）
+	Produces     string              `toml:"produces"`      // 产出的资源（wood/stone/iron/food，为空表示不产出）
+	InitialLevel int                 `toml:"initial_level"` // 新城池的初始等级
+	MaxLevel     int                 `toml:"max_level"`
+	Levels       []BuildingLevelConf `toml:"levels"`
 }
 
-// BuildingConf 建筑配置
+// BuildingConf 建筑配置（键为建筑类型）
 type BuildingConf struct {
-	Building map[string]struct {
-		InitialLevel int                 `toml:"initial_level"`
-		MaxLevel     int                 `toml:"max_level"`
-		Levels       []BuildingLevelConf `toml:"levels"`
-	} `toml:"building"`
+	Building map[string]*BuildingTypeConf `toml:"building"`
 }
 
+// 建筑可产出的资源
+const (
+	ResourceWood  = "wood"
+	ResourceStone = "stone"
+	ResourceIron  = "iron"
+	ResourceFood  = "food"
+)
+
 // TroopConf 部队配置
 type TroopConf struct {
-	Troops []struct {
-		Type               string `toml:"type"`
-		Name               string `toml:"name"`
-		MeleeAttack        int    `toml:"melee_attack"`
-		RangedAttack       int    `toml:"ranged_attack"`
-		MeleeDefense       int    `toml:"melee_defense"`
-		RangedDefense      int    `toml:"ranged_defense"`
-		Speed              int    `toml:"speed"`
-		Capacity           int    `toml:"capacity"`
-		FoodConsumption    int    `toml:"food_consumption"`
-		RecruitTimeSeconds int    `toml:"recruit_time_seconds"`
-		RecruitCostWood    int    `toml:"recruit_cost_wood"`
-		RecruitCostIron    int    `toml:"recruit_cost_iron"`
-		RecruitCostFood    int    `toml:"recruit_cost_food"`
-		RecruitCostStone   int    `toml:"recruit_cost_stone"`
-	} `toml:"troop"`
+	Troops []TroopAttr `toml:"troop"`
+}
+
+// TaxConf 税收配置
+type TaxConf struct {
+	BaseGoldPerHour        float64 `toml:"base_gold_per_hour"`       // 每小时基础金币
+	GoldPerPopulation      float64 `toml:"gold_per_population"`      // 每个平民人口每小时金币
+	GovernmentBonusPercent float64 `toml:"government_bonus_percent"` // 官府每级税收加成百分比
+}
+
+// QueueConf 队列配置
+type QueueConf struct {
+	CancelRefundPercent int `toml:"cancel_refund_percent"` // 取消队列任务时返还资源的百分比
+}
+
+// EconomyConf 经济配置
+type EconomyConf struct {
+	Tax   TaxConf   `toml:"tax"`
+	Queue QueueConf `toml:"queue"`
 }
 
 // LoadConfig 加载所有配置（启动时调用一次）
@@ -74,6 +104,10 @@ func LoadConfig() error {
 			loadErr = err
 			return
 		}
+		if buildingTypes, err = buildBuildingTypes(BuildingConfig); err != nil {
+			loadErr = err
+			return
+		}
 
 		// 加载部队配置
 		troopData, err := os.ReadFile("conf/troops.toml")
@@ -86,6 +120,54 @@ func LoadConfig() error {
 			loadErr = err
 			return
 		}
+		if troopIndex, err = buildTroopIndex(TroopConfig); err != nil {
+			loadErr = err
+			return
+		}
+
+		// 加载经济配置
+		economyData, err := os.ReadFile("conf/economy.toml")
+		if err != nil {
+			loadErr = err
+			return
+		}
+		EconomyConfig = &EconomyConf{}
+		if err := toml.Unmarshal(economyData, EconomyConfig); err != nil {
+			loadErr = err
+			return
+		}
+
+		// 加载科技配置
+		researchData, err := os.ReadFile("conf/research.toml")
+		if err != nil {
+			loadErr = err
+			return
+		}
+		ResearchConfig = &ResearchConf{}
+		if err := toml.Unmarshal(researchData, ResearchConfig); err != nil {
+			loadErr = err
+			return
+		}
+		if researchTypes, err = buildResearchTypes(ResearchConfig); err != nil {
+			loadErr = err
+			return
+		}
+
+		// 加载服务器配置
+		serverData, err := os.ReadFile("conf/server.toml")
+		if err != nil {
+			loadErr = err
+			return
+		}
+		ServerConfig = &ServerConf{}
+		if err := toml.Unmarshal(serverData, ServerConfig); err != nil {
+			loadErr = err
+			return
+		}
+		if err := validateServerConf(ServerConfig); err != nil {
+			loadErr = err
+			return
+		}
 	})
 	return loadErr
 }
@@ -96,7 +178,7 @@ func GetBuildingLevel(buildingType string, level int) *BuildingLevelConf {
 		return nil
 	}
 	bConf, ok := BuildingConfig.Building[buildingType]
-	if !ok {
+	if !ok || bConf == nil {
 		return nil
 	}
 	for _, lv := range bConf.Levels {
@@ -107,49 +189,124 @@ func GetBuildingLevel(buildingType string, level int) *BuildingLevelConf {
 	return nil
 }
 
+// buildBuildingTypes 校验建筑配置并按显示顺序排列建筑类型（加载配置时调用）
+func buildBuildingTypes(conf *BuildingConf) ([]string, error) {
+	types := make([]string, 0, len(conf.Building))
+	for buildingType, bConf := range conf.Building {
+		switch bConf.Produces {
+		case "", ResourceWood, ResourceStone, ResourceIron, ResourceFood:
+		default:
+			return nil, fmt.Errorf("building %s: unknown produced resource %q", buildingType, bConf.Produces)
+		}
+		for _, lv := range bConf.Levels {
+			if err := validateRequires(conf, lv.Requires); err != nil {
+				return nil, fmt.Errorf("building %s level %d: %w", buildingType, lv.Level, err)
+			}
+		}
+		types = append(types, buildingType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		oi, oj := conf.Building[types[i]].Order, conf.Building[types[j]].Order
+		if oi != oj {
+			return oi < oj
+		}
+		return types[i] < types[j]
+	})
+	return types, nil
+}
+
+// ListBuildingTypes 获取所有建筑类型（按显示顺序）
+func ListBuildingTypes() []string {
+	return buildingTypes
+}
+
+// GetBuildingTypeConf 获取指定建筑类型的配置
+func GetBuildingTypeConf(buildingType string) *BuildingTypeConf {
+	if BuildingConfig == nil {
+		return nil
+	}
+	return BuildingConfig.Building[buildingType]
+}
+
+// GetBuildingInitialLevel 获取指定建筑的初始等级
+func GetBuildingInitialLevel(buildingType string) int {
+	if bConf := GetBuildingTypeConf(buildingType); bConf != nil {
+		return bConf.InitialLevel
+	}
+	return 0
+}
+
+// 兵种职能（决定兵种的特殊用途，未配置的兵种为普通作战部队）
+const (
+	TroopRoleSettler   = "settler"   // 拓荒：可在空地建立新城池
+	TroopRoleScout     = "scout"     // 侦察：可刺探敌方城池
+	TroopRoleTransport = "transport" // 运输：可在己方城池间运送资源
+)
+
 // TroopAttr 兵种属性
 type TroopAttr struct {
-	Type               string `json:"type"`
-	Name               string `json:"name"`
-	MeleeAttack        int    `json:"melee_attack"`
-	RangedAttack       int    `json:"ranged_attack"`
-	MeleeDefense       int    `json:"melee_defense"`
-	RangedDefense      int    `json:"ranged_defense"`
-	Speed              int    `json:"speed"`
-	Capacity           int    `json:"capacity"`
-	FoodConsumption    int    `json:"food_consumption"`
-	RecruitTimeSeconds int    `json:"recruit_time_seconds"`
-	RecruitCostWood    int    `json:"recruit_cost_wood"`
-	RecruitCostIron    int    `json:"recruit_cost_iron"`
-	RecruitCostFood    int    `json:"recruit_cost_food"`
-	RecruitCostStone   int    `json:"recruit_cost_stone"`
-}
-
-// GetTroopConfig 获取指定兵种配置
+	Type               string `toml:"type" json:"type"`
+	Name               string `toml:"name" json:"name"`
+	Role               string `toml:"role" json:"role"`
+	MeleeAttack        int    `toml:"melee_attack" json:"melee_attack"`
+	RangedAttack       int    `toml:"ranged_attack" json:"ranged_attack"`
+	MeleeDefense       int    `toml:"melee_defense" json:"melee_defense"`
+	RangedDefense      int    `toml:"ranged_defense" json:"ranged_defense"`
+	Speed              int    `toml:"speed" json:"speed"`
+	Capacity           int    `toml:"capacity" json:"capacity"`
+	FoodConsumption    int    `toml:"food_consumption" json:"food_consumption"`
+	PopulationCost     int    `toml:"population_cost" json:"population_cost"`
+	RecruitTimeSeconds int    `toml:"recruit_time_seconds" json:"recruit_time_seconds"`
+	RecruitCostWood    int    `toml:"recruit_cost_wood" json:"recruit_cost_wood"`
+	RecruitCostIron    int    `toml:"recruit_cost_iron" json:"recruit_cost_iron"`
+	RecruitCostFood    int    `toml:"recruit_cost_food" json:"recruit_cost_food"`
+	RecruitCostStone   int    `toml:"recruit_cost_stone" json:"recruit_cost_stone"`
+
+	Requires map[string]int `toml:"requires" json:"requires,omitempty"` // 解锁招募的前置建筑（建筑类型 -> 最低等级）
+}
+
+// validateRequires 校验前置条件引用的建筑类型是否存在
+func validateRequires(conf *BuildingConf, requires map[string]int) error {
+	for buildingType := range requires {
+		if _, ok := conf.Building[buildingType]; !ok {
+			return fmt.Errorf("unknown required building %q", buildingType)
+		}
+	}
+	return nil
+}
+
+// buildTroopIndex 建立兵种类型索引（加载配置时调用，需在建筑配置之后）
+func buildTroopIndex(conf *TroopConf) (map[string]*TroopAttr, error) {
+	index := make(map[string]*TroopAttr, len(conf.Troops))
+	for i := range conf.Troops {
+		t := &conf.Troops[i]
+		if t.Type == "" {
+			return nil, fmt.Errorf("troop #%d: missing type", i+1)
+		}
+		if _, exists := index[t.Type]; exists {
+			return nil, fmt.Errorf("duplicate troop type: %s", t.Type)
+		}
+		if err := validateRequires(BuildingConfig, t.Requires); err != nil {
+			return nil, fmt.Errorf("troop %s: %w", t.Type, err)
+		}
+		index[t.Type] = t
+	}
+	return index, nil
+}
+
+// GetTroopConfig 获取指定兵种配置（返回共享配置，调用者不可修改）
 func GetTroopConfig(troopType string) *TroopAttr {
+	return troopIndex[troopType]
+}
+
+// ListTroopConfigs 获取所有兵种配置（按配置文件顺序）
+func ListTroopConfigs() []*TroopAttr {
 	if TroopConfig == nil {
 		return nil
 	}
+	troops := make([]*TroopAttr, 0, len(TroopConfig.Troops))
 	for i := range TroopConfig.Troops {
-		if TroopConfig.Troops[i].Type == troopType {
-			t := &TroopConfig.Troops[i]
-			return &TroopAttr{
-				Type:               t.Type,
-				Name:               t.Name,
-				MeleeAttack:        t.MeleeAttack,
-				RangedAttack:       t.RangedAttack,
-				MeleeDefense:       t.MeleeDefense,
-				RangedDefense:      t.RangedDefense,
-				Speed:              t.Speed,
-				Capacity:           t.Capacity,
-				FoodConsumption:    t.FoodConsumption,
-				RecruitTimeSeconds: t.RecruitTimeSeconds,
-				RecruitCostWood:    t.RecruitCostWood,
-				RecruitCostIron:    t.RecruitCostIron,
-				RecruitCostFood:    t.RecruitCostFood,
-				RecruitCostStone:   t.RecruitCostStone,
-			}
-		}
+		troops = append(troops, &TroopConfig.Troops[i])
 	}
-	return nil
+	return troops
 }
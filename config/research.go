@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// 科技效果类型（加成均为百分比）
+const (
+	ResearchEffectProduction    = "production"     // 木/石/铁/粮产量
+	ResearchEffectAttack        = "attack"         // 部队近战和远程攻击力
+	ResearchEffectMarchSpeed    = "march_speed"    // 行军速度
+	ResearchEffectCarryCapacity = "carry_capacity" // 部队负重
+)
+
+// ResearchLevelConf 科技等级配置
+type ResearchLevelConf struct {
+	Level               int `toml:"level" json:"level"`
+	BonusPercent        int `toml:"bonus_percent" json:"bonus_percent"` // 达到该等级后的总加成百分比
+	ResearchTimeSeconds int `toml:"research_time_seconds" json:"research_time_seconds"`
+	CostWood            int `toml:"cost_wood" json:"cost_wood"`
+	CostStone           int `toml:"cost_stone" json:"cost_stone"`
+	CostIron            int `toml:"cost_iron" json:"cost_iron"`
+	CostFood            int `toml:"cost_food" json:"cost_food"`
+	CostGold            int `toml:"cost_gold" json:"cost_gold"`
+
+	Requires map[string]int `toml:"requires" json:"requires,omitempty"` // 研究该等级的前置建筑（研究城池的建筑类型 -> 最低等级）
+}
+
+// ResearchTypeConf 单项科技的配置
+type ResearchTypeConf struct {
+	Name   string              `toml:"name"`   // 中文名称
+	Order  int                 `toml:"order"`  // 显示顺序（从小到大）
+	Effect string              `toml:"effect"` // 效果类型（ResearchEffectXxx）
+	Levels []ResearchLevelConf `toml:"levels"` // 从1级开始，0级表示未研究
+}
+
+// ResearchConf 科技配置（键为科技类型）
+type ResearchConf struct {
+	Research map[string]*ResearchTypeConf `toml:"research"`
+}
+
+// buildResearchTypes 校验科技配置并按显示顺序排列科技类型（加载配置时调用，需在建筑配置之后）
+func buildResearchTypes(conf *ResearchConf) ([]string, error) {
+	types := make([]string, 0, len(conf.Research))
+	for researchType, rConf := range conf.Research {
+		switch rConf.Effect {
+		case ResearchEffectProduction, ResearchEffectAttack, ResearchEffectMarchSpeed, ResearchEffectCarryCapacity:
+		default:
+			return nil, fmt.Errorf("research %s: unknown effect %q", researchType, rConf.Effect)
+		}
+		for i, lv := range rConf.Levels {
+			if lv.Level != i+1 {
+				return nil, fmt.Errorf("research %s: levels must start at 1 and be consecutive", researchType)
+			}
+			if err := validateRequires(BuildingConfig, lv.Requires); err != nil {
+				return nil, fmt.Errorf("research %s level %d: %w", researchType, lv.Level, err)
+			}
+		}
+		types = append(types, researchType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		oi, oj := conf.Research[types[i]].Order, conf.Research[types[j]].Order
+		if oi != oj {
+			return oi < oj
+		}
+		return types[i] < types[j]
+	})
+	return types, nil
+}
+
+// ListResearchTypes 获取所有科技类型（按显示顺序）
+func ListResearchTypes() []string {
+	return researchTypes
+}
+
+// GetResearchTypeConf 获取指定科技的配置
+func GetResearchTypeConf(researchType string) *ResearchTypeConf {
+	if ResearchConfig == nil {
+		return nil
+	}
+	return ResearchConfig.Research[researchType]
+}
+
+// GetResearchLevel 获取指定科技的指定等级配置（0级或超出最高等级返回 nil）
+func GetResearchLevel(researchType string, level int) *ResearchLevelConf {
+	rConf := GetResearchTypeConf(researchType)
+	if rConf == nil || level < 1 || level > len(rConf.Levels) {
+		return nil
+	}
+	return &rConf.Levels[level-1]
+}
@@ -0,0 +1,64 @@
+package config
+
+import "fmt"
+
+// 存储后端类型
+const (
+	StorageBackendJSON   = "json"   // JSON 快照文件（./data/snapshots）
+	StorageBackendSQLite = "sqlite" // 内嵌 SQLite 数据库，玩家、城池、行军和战报按行存储
+)
+
+// 快照压缩算法
+const (
+	CompressionNone = "none" // 不压缩
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// StorageConf 存储配置
+type StorageConf struct {
+	Backend         string        `toml:"backend"`            // 存储后端（json/sqlite）
+	CompactEvery    int           `toml:"compact_every"`      // JSON 后端：每保存多少次增量快照后合并为一次全量快照
+	Compression     string        `toml:"compression"`        // JSON 后端：快照压缩算法（none/gzip/zstd）
+	Retention       RetentionConf `toml:"retention"`          // JSON 后端：全量快照保留策略
+	SQLitePath      string        `toml:"sqlite_path"`        // SQLite 数据库文件路径
+	DiscardWALOnGap bool          `toml:"discard_wal_on_gap"` // 快照比命令日志旧（命令序号有缺口）时丢弃缺口之后的命令，而不是拒绝启动
+}
+
+// RetentionConf 分级保留策略（为 0 的级别不启用，超出所有级别的快照被删除，最新的快照始终保留）
+type RetentionConf struct {
+	KeepAllMinutes int `toml:"keep_all_minutes"` // 最近多少分钟内的快照全部保留
+	HourlyHours    int `toml:"hourly_hours"`     // 最近多少小时内每小时保留一个
+	DailyDays      int `toml:"daily_days"`       // 最近多少天内每天保留一个
+}
+
+// ServerConf 服务器配置
+type ServerConf struct {
+	Storage StorageConf `toml:"storage"`
+}
+
+// validateServerConf 校验服务器配置（加载配置时调用）
+func validateServerConf(conf *ServerConf) error {
+	switch conf.Storage.Backend {
+	case StorageBackendJSON:
+		if conf.Storage.CompactEvery <= 0 {
+			return fmt.Errorf("storage: compact_every must be positive for backend %q", conf.Storage.Backend)
+		}
+		switch conf.Storage.Compression {
+		case CompressionNone, CompressionGzip, CompressionZstd:
+		default:
+			return fmt.Errorf("storage: unknown compression %q", conf.Storage.Compression)
+		}
+		r := conf.Storage.Retention
+		if r.KeepAllMinutes < 0 || r.HourlyHours < 0 || r.DailyDays < 0 {
+			return fmt.Errorf("storage: retention periods must not be negative")
+		}
+	case StorageBackendSQLite:
+		if conf.Storage.SQLitePath == "" {
+			return fmt.Errorf("storage: sqlite_path is required for backend %q", conf.Storage.Backend)
+		}
+	default:
+		return fmt.Errorf("storage: unknown backend %q", conf.Storage.Backend)
+	}
+	return nil
+}